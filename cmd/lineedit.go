@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// lineReader reads lines of shell.
+type lineReader interface {
+	// readLine prints prompt and returns entered line, io.EOF when input is over.
+	readLine(prompt string) (string, error)
+}
+
+// newLineReader returns editor with tab completion when in is terminal,
+// otherwise input is read line by line, like script piped to shell.
+func newLineReader(in io.Reader, out io.Writer, complete func(line string) []string) lineReader {
+	file, ok := in.(*os.File)
+	if !ok || !term.IsTerminal(int(file.Fd())) {
+		return &scannerReader{in: bufio.NewScanner(in), out: out}
+	}
+
+	fd := int(file.Fd())
+	return &lineEditor{
+		in:       bufio.NewReader(in),
+		out:      out,
+		complete: complete,
+		raw: func() (func(), error) {
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				return nil, err
+			}
+
+			return func() { term.Restore(fd, state) }, nil
+		},
+	}
+}
+
+type scannerReader struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func (r *scannerReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.in.Scan() {
+		fmt.Fprintln(r.out)
+		if r.in.Err() != nil {
+			return "", r.in.Err()
+		}
+
+		return "", io.EOF
+	}
+
+	return r.in.Text(), nil
+}
+
+// lineEditor reads line of terminal key by key: Tab completes the last word, Backspace
+// removes rune, Ctrl-C drops line and Ctrl-D ends input when line is empty. Terminal is
+// raw only while line is read, so output of commands is written as usual.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	complete func(line string) []string
+	// raw switches terminal to raw mode and returns function which restores it.
+	raw func() (func(), error)
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	fmt.Fprint(e.out, prompt)
+	line := []rune{}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			fmt.Fprint(e.out, "\r\n")
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n"+prompt)
+			line = line[:0]
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case 127, '\b':
+			if len(line) != 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(e.out, "\b \b")
+			}
+		case '\t':
+			line = e.completeLine(prompt, line)
+		case 27: // arrows and other keys with escape sequences aren't supported
+			e.skipEscape()
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line, r)
+				fmt.Fprint(e.out, string(r))
+			}
+		}
+	}
+}
+
+// completeLine adds to line common prefix of completions of the last word,
+// completion is followed by space when it is the only one. When nothing can be added
+// completions are listed under line.
+func (e *lineEditor) completeLine(prompt string, line []rune) []rune {
+	variants := e.complete(string(line))
+	if len(variants) == 0 {
+		return line
+	}
+
+	word := []rune{}
+	if len(line) != 0 && line[len(line)-1] != ' ' {
+		fields := strings.Fields(string(line))
+		word = []rune(fields[len(fields)-1])
+	}
+
+	common := []rune(variants[0])
+	for _, variant := range variants[1:] {
+		common = commonPrefix(common, []rune(variant))
+	}
+	if len(variants) == 1 {
+		common = append(common, ' ')
+	}
+
+	if len(common) > len(word) {
+		added := common[len(word):]
+		fmt.Fprint(e.out, string(added))
+		return append(line, added...)
+	}
+
+	fmt.Fprint(e.out, "\r\n"+strings.Join(variants, "  ")+"\r\n"+prompt+string(line))
+	return line
+}
+
+func commonPrefix(a []rune, b []rune) []rune {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return a[:n]
+}
+
+// skipEscape reads the rest of escape sequence like ESC [ A of arrow up.
+func (e *lineEditor) skipEscape() {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil || (r >= 0x40 && r <= 0x7e) {
+			return
+		}
+	}
+}
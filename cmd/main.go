@@ -1,52 +1,781 @@
 package main
 
 import (
-	"sync"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
-	"github.com/anonimous-arn/wallet/pkg/wallet"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/anonimous-arn/wallet/pkg/types"
-	
+	"github.com/anonimous-arn/wallet/pkg/wallet"
 )
 
+var errUsage = errors.New("wrong arguments")
+
+const usage = `usage: wallet [-data dir] [-audit file] [-actor name] [-json] [-locale en|ru|tg] [-v] <command> [arguments]
+
+money operations are written to audit log, default is audit.log in data directory,
+with actor, default is system. Loading of data directory before command isn't written.
+amounts are written in locale like 1234.50 or with currency like '1 234 TJS', number
+without decimal separator must have currency. Text output writes them in locale, JSON output
+in minor units.
+
+commands:
+  register <phone>                   register account
+  deposit [-source s] <account> <amount>
+                                     deposit money to account
+  change-phone <account> <phone>     move account to new phone
+  phone-history <account>            list phone changes of account
+  freeze <account>                   stop deposits and payments of account
+  unfreeze <account>                 make frozen account active
+  close <account>                    close account with zero balance
+  merge <account> <duplicate>        move balance, payments and favorites of duplicate
+                                     account to account and remove duplicate
+  pay <account> <amount> <category>  make payment
+  pay-phone <account> <phone> <amount>
+                                     send money to phone, it waits for registration
+                                     of phone if it isn't registered
+  expire-claims                      return expired transfers to senders
+  reject <payment>                   reject payment and return money
+  repeat <payment>                   repeat payment
+  favorite <payment> <name>          add payment to favorites
+  pay-favorite <favorite>            pay from favorite
+  history <account>                  list payments of account
+  export <dir>                       write dumps to dir
+  import [-human] <dir>              read dumps from dir, with -human amounts of accounts,
+                                     payments and favorites are written in locale
+  sum [-goroutines n]                sum of all payments
+  filter [-goroutines n] <expression>
+                                     list payments matching expression,
+                                     like 'category=Cafe and amount>1.00', amounts are written in locale
+  aggregate [-by category|account|status] [-goroutines n] [-csv]
+                                     totals, averages and percentiles of payments
+  top [-n 10] [-goroutines n] accounts|categories|payments
+                                     biggest spenders, categories or payments
+  spending [-period day|week|month] [-by category|account] [-from date] [-to date]
+                                     chart of spending, dates like 2006-01-02
+  shell                              interactive shell
+`
+
+type cli struct {
+	svc     *wallet.Service
+	dataDir string
+	json    bool
+	locale  wallet.Locale
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+type command struct {
+	run     func(c *cli, args []string) (interface{}, error)
+	changes bool
+}
+
+var commands = map[string]command{
+	"register":      {run: (*cli).register, changes: true},
+	"deposit":       {run: (*cli).deposit, changes: true},
+	"change-phone":  {run: (*cli).changePhone, changes: true},
+	"phone-history": {run: (*cli).phoneHistory},
+	"freeze":        {run: (*cli).freeze, changes: true},
+	"unfreeze":      {run: (*cli).unfreeze, changes: true},
+	"close":         {run: (*cli).close, changes: true},
+	"merge":         {run: (*cli).merge, changes: true},
+	"pay":           {run: (*cli).pay, changes: true},
+	"pay-phone":     {run: (*cli).payPhone, changes: true},
+	"expire-claims": {run: (*cli).expireClaims, changes: true},
+	"reject":        {run: (*cli).reject, changes: true},
+	"repeat":        {run: (*cli).repeat, changes: true},
+	"favorite":      {run: (*cli).favorite, changes: true},
+	"pay-favorite":  {run: (*cli).payFavorite, changes: true},
+	"history":       {run: (*cli).history},
+	"export":        {run: (*cli).export},
+	"import":        {run: (*cli).importDumps, changes: true},
+	"sum":           {run: (*cli).sum},
+	"filter":        {run: (*cli).filter},
+	"aggregate":     {run: (*cli).aggregate},
+	"top":           {run: (*cli).top},
+	"spending":      {run: (*cli).spending},
+}
+
+func init() {
+	// shell runs other commands, so it can't be in initializer of commands
+	commands["shell"] = command{run: (*cli).shell}
+}
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run loads service from data directory, executes command and saves service back
+// when command changes it. It returns exit code.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	dataDir := flags.String("data", "data", "directory with dump files")
+	auditPath := flags.String("audit", "", "audit log, default is audit.log in data directory")
+	actor := flags.String("actor", "", "who runs command, it is written to audit log")
+	jsonOutput := flags.Bool("json", false, "print result as JSON")
+	localeName := flags.String("locale", "", "locale of amounts: en, ru or tg, default is 1 234.50 TJS")
+	verbose := flags.Bool("v", false, "print log of service")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
 
-	var svc wallet.Service
+	log.SetOutput(stderr)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
 
-	account, err := svc.RegisterAccount("+992988000011")
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
 
+	locale, err := wallet.FindLocale(*localeName)
 	if err != nil {
-		log.Printf("method RegisterAccount returned not nil error, account => %v", account)
+		fmt.Fprintf(stderr, "%v\n\n%s", err, usage)
+		return 2
 	}
 
-	err = svc.Deposit(account.ID, 1234300_123223_00000)
+	c := &cli{svc: &wallet.Service{}, dataDir: *dataDir, json: *jsonOutput, locale: locale, stdin: stdin, stdout: stdout}
+
+	auditLog, err := openAuditLog(c.dataDir, *auditPath)
 	if err != nil {
-		log.Printf("method Deposit returned not nil error, error => %v", err)
+		fmt.Fprintf(stderr, "can't open audit log: %v\n", err)
+		return 1
+	}
+	defer func() {
+		err := auditLog.Close()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+	}()
+
+	// loading of state isn't operation of actor, so audit log is set after it
+	err = c.svc.Import(c.dataDir)
+	conflict := &wallet.PhoneConflictError{}
+	if errors.As(err, &conflict) {
+		// accounts of old dumps with the same phone are loaded, so they can be merged
+		fmt.Fprintf(stderr, "warning: %v, merge duplicate accounts\n", err)
+	} else if err != nil {
+		fmt.Fprintf(stderr, "can't load %s: %v\n", c.dataDir, err)
+		return 1
 	}
+	c.svc.SetAuditLog(auditLog)
+	c.svc.SetActor(*actor)
 
-	wg := sync.WaitGroup{}
-	wg.Add(2)
+	result, err := cmd.run(c, flags.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(stderr, "%s: %v\n\n%s", name, err, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", name, err)
+		return 1
+	}
 
-	for i := 0; i < 1000; i++ {
-		svc.Pay(account.ID, types.Money(i), "Cafe")
+	if cmd.changes {
+		err = c.save()
+		if err != nil {
+			fmt.Fprintf(stderr, "can't save %s: %v\n", c.dataDir, err)
+			return 1
+		}
 	}
 
-	var ch <-chan types.Progress
-	go func() {
-		defer wg.Done()
-		ch = svc.SumPaymentsWithProgress()
-	}()
-	go func() {
-		defer wg.Done()
-		ch = svc.SumPaymentsWithProgress()
-	}()
+	err = c.print(result)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
 
-	wg.Wait()
+	return 0
+}
 
-	s, ok := <-ch
+// openAuditLog opens audit log at path or audit.log in data directory when path is empty.
+func openAuditLog(dataDir string, path string) (*wallet.AuditLog, error) {
+	if path == "" {
+		err := os.MkdirAll(dataDir, 0755)
+		if err != nil {
+			return nil, err
+		}
 
-	if !ok {
-		log.Printf(" method SumPaymentsWithProgress ok not closed => %v", ok)
+		path = dataDir + "/audit.log"
+	}
+
+	return wallet.OpenAuditLog(path)
+}
+
+func (c *cli) save() error {
+	err := os.MkdirAll(c.dataDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return c.svc.Export(c.dataDir)
+}
+
+func (c *cli) print(result interface{}) error {
+	if c.json {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	var err error
+	switch value := result.(type) {
+	case nil:
+		return nil
+	case *types.Account:
+		_, err = fmt.Fprintf(c.stdout, "account %d  phone %s  balance %s  status %s\n", value.ID, value.Phone, c.money(value.Balance), value.Status)
+	case *types.Payment:
+		_, err = c.printPayment(*value)
+	case []types.Payment:
+		for _, payment := range value {
+			_, err = c.printPayment(payment)
+			if err != nil {
+				return err
+			}
+		}
+	case *types.Favorite:
+		_, err = fmt.Fprintf(c.stdout, "favorite %s  %q  account %d  amount %s  category %s\n",
+			value.ID, value.Name, value.AccountID, c.money(value.Amount), value.Category)
+	case map[string]string:
+		for key, item := range value {
+			_, err = fmt.Fprintf(c.stdout, "%s %s\n", key, item)
+		}
+	case types.AggregateReport:
+		_, err = fmt.Fprintf(c.stdout, "%-20s %8s %18s %18s %18s %18s %18s\n", value.GroupBy, "count", "total", "average", "p50", "p90", "p99")
+		for _, group := range value.Groups {
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(c.stdout, "%-20s %8d %18s %18s %18s %18s %18s\n", group.Key, group.Count,
+				c.money(group.Total), c.money(group.Average), c.money(group.P50), c.money(group.P90), c.money(group.P99))
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(c.stdout, "%-20s %8d %18s\n", "total", value.Count, c.money(value.Total))
+		}
+	case types.SpendingReport:
+		err = wallet.WriteSeriesChart(c.stdout, value, c.locale)
+	case *types.PhoneChange:
+		_, err = printPhoneChange(c.stdout, *value)
+	case []types.PhoneChange:
+		for _, change := range value {
+			_, err = printPhoneChange(c.stdout, change)
+			if err != nil {
+				return err
+			}
+		}
+	case *types.AccountMerge:
+		_, err = fmt.Fprintf(c.stdout, "account %d  merged into %d  amount %s  payments %d  favorites %d\n",
+			value.AccountID, value.IntoAccountID, c.money(value.Amount), value.Payments, value.Favorites)
+	case *types.Transfer:
+		_, err = fmt.Fprintf(c.stdout, "transfer %s  account %d  phone %s  amount %s  status %s\n",
+			value.ID, value.FromAccountID, value.Phone, c.money(value.Amount), value.Status)
+	case []types.TopEntry:
+		for i, entry := range value {
+			_, err = fmt.Fprintf(c.stdout, "%3d. %-20s total %s  payments %d\n", i+1, entry.Key, c.money(entry.Total), entry.Count)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]types.Money:
+		for key, item := range value {
+			_, err = fmt.Fprintf(c.stdout, "%s %s\n", key, c.money(item))
+		}
+	default:
+		_, err = fmt.Fprintln(c.stdout, value)
+	}
+
+	return err
+}
+
+// money formats amount in locale of cli.
+func (c *cli) money(amount types.Money) string {
+	return wallet.FormatMoney(amount, c.locale)
+}
+
+func (c *cli) printPayment(payment types.Payment) (int, error) {
+	return fmt.Fprintf(c.stdout, "payment %s  account %d  amount %s  category %s  status %s\n",
+		payment.ID, payment.AccountID, c.money(payment.Amount), payment.Category, payment.Status)
+}
+
+func printPhoneChange(w io.Writer, change types.PhoneChange) (int, error) {
+	return fmt.Fprintf(w, "account %d  phone %s -> %s  at %s\n",
+		change.AccountID, change.OldPhone, change.NewPhone, time.Unix(change.Time, 0).UTC().Format(time.RFC3339))
+}
+
+func parseAccountID(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad account id %q", errUsage, value)
+	}
+
+	return id, nil
+}
+
+// parseAmount parses money like 1234.50 or money with currency like '1 234 TJS' in locale of cli.
+// Number without decimal separator and currency is rejected: 1250 could be 12.50 or 1 250.00.
+func (c *cli) parseAmount(value string) (types.Money, error) {
+	money, err := wallet.ParseAmount(value, c.locale)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	return money, nil
+}
+
+// parseDate parses date like 2006-01-02 in UTC, empty value is zero time.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: bad date %q", errUsage, value)
+	}
+
+	return date, nil
+}
+
+func needArgs(args []string, count int) error {
+	if len(args) != count {
+		return fmt.Errorf("%w: need %d arguments, got %d", errUsage, count, len(args))
+	}
+
+	return nil
+}
+
+func (c *cli) register(args []string) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.RegisterAccount(types.Phone(args[0]))
+}
+
+func (c *cli) deposit(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("deposit", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	source := flags.String("source", wallet.DefaultDepositSource, "source of money")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	err = needArgs(flags.Args(), 2)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseAccountID(flags.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := c.parseAmount(flags.Arg(1))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.svc.DepositFrom(id, amount, *source)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.FindAccountByID(id)
+}
+
+func (c *cli) changePhone(args []string) (interface{}, error) {
+	err := needArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseAccountID(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.ChangePhone(id, types.Phone(args[1]))
+}
+
+func (c *cli) phoneHistory(args []string) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseAccountID(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.PhoneHistory(id)
+}
+
+// changeStatus runs change of account status and returns changed account.
+func (c *cli) changeStatus(args []string, change func(accountID int64) error) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseAccountID(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = change(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.FindAccountByID(id)
+}
+
+func (c *cli) freeze(args []string) (interface{}, error) {
+	return c.changeStatus(args, c.svc.Freeze)
+}
+
+func (c *cli) unfreeze(args []string) (interface{}, error) {
+	return c.changeStatus(args, c.svc.Unfreeze)
+}
+
+func (c *cli) close(args []string) (interface{}, error) {
+	return c.changeStatus(args, c.svc.Close)
+}
+
+func (c *cli) merge(args []string) (interface{}, error) {
+	err := needArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	keepID, err := parseAccountID(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	mergeID, err := parseAccountID(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.MergeAccounts(keepID, mergeID)
+}
+
+func (c *cli) pay(args []string) (interface{}, error) {
+	err := needArgs(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseAccountID(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := c.parseAmount(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.Pay(id, amount, types.PaymentCategory(args[2]))
+}
+
+func (c *cli) payPhone(args []string) (interface{}, error) {
+	err := needArgs(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseAccountID(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := c.parseAmount(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.PayToPhone(id, types.Phone(args[1]), amount)
+}
+
+func (c *cli) expireClaims(args []string) (interface{}, error) {
+	err := needArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := c.svc.ExpireClaims()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"expired": strconv.Itoa(count)}, nil
+}
+
+func (c *cli) reject(args []string) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.svc.Reject(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.FindPaymentByID(args[0])
+}
+
+func (c *cli) repeat(args []string) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.Repeat(args[0])
+}
+
+func (c *cli) favorite(args []string) (interface{}, error) {
+	err := needArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.FavoritePayment(args[0], args[1])
+}
+
+func (c *cli) payFavorite(args []string) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.svc.PayFromFavorite(args[0])
+}
+
+func (c *cli) history(args []string) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseAccountID(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := c.svc.ExportAccountHistory(id)
+	if errors.Is(err, wallet.ErrPaymentNotFound) {
+		return []types.Payment{}, nil
+	}
+
+	return payments, err
+}
+
+func (c *cli) export(args []string) (interface{}, error) {
+	err := needArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(args[0], 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.svc.Export(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"exported": args[0]}, nil
+}
+
+func (c *cli) importDumps(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	human := flags.Bool("human", false, "amounts are written in locale")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	err = needArgs(flags.Args(), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := flags.Arg(0)
+	if *human {
+		err = c.svc.ImportHuman(dir, c.locale)
+	} else {
+		err = c.svc.Import(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"imported": dir}, nil
+}
+
+func (c *cli) sum(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("sum", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	goroutines := flags.Int("goroutines", 1, "number of goroutines")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	err = needArgs(flags.Args(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := c.svc.SumPaymentsContext(context.Background(), *goroutines)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]types.Money{"sum": sum}, nil
+}
+
+func (c *cli) filter(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("filter", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	goroutines := flags.Int("goroutines", 1, "number of goroutines")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if flags.NArg() == 0 {
+		return nil, fmt.Errorf("%w: need expression", errUsage)
+	}
+
+	predicate, err := wallet.ParsePredicateLocale(strings.Join(flags.Args(), " "), c.locale)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := c.svc.FilterPaymentsByFn(predicate, *goroutines)
+	if err != nil {
+		return nil, err
+	}
+
+	if payments == nil {
+		payments = []types.Payment{}
+	}
+
+	return payments, nil
+}
+
+func (c *cli) aggregate(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("aggregate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	by := flags.String("by", string(types.GroupByCategory), "category, account or status")
+	goroutines := flags.Int("goroutines", 1, "number of goroutines")
+	csv := flags.Bool("csv", false, "print report as CSV")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	err = needArgs(flags.Args(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := c.svc.AggregatePayments(types.GroupBy(*by), *goroutines)
+	if err != nil {
+		return nil, err
+	}
+
+	if *csv && !c.json {
+		return nil, wallet.WriteAggregateCSV(c.stdout, report)
+	}
+
+	return report, nil
+}
+
+func (c *cli) top(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	n := flags.Int("n", 10, "size of top")
+	goroutines := flags.Int("goroutines", 1, "number of goroutines")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	err = needArgs(flags.Args(), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch flags.Arg(0) {
+	case "accounts":
+		return c.svc.TopAccounts(*n, *goroutines)
+	case "categories":
+		return c.svc.TopCategories(*n, *goroutines)
+	case "payments":
+		return c.svc.TopPayments(*n, *goroutines)
+	}
+
+	return nil, fmt.Errorf("%w: unknown top %q", errUsage, flags.Arg(0))
+}
+
+func (c *cli) spending(args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("spending", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	period := flags.String("period", string(types.PeriodMonth), "day, week or month")
+	by := flags.String("by", "", "category or account")
+	from := flags.String("from", "", "first date")
+	to := flags.String("to", "", "date after the last one")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	err = needArgs(flags.Args(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fromDate, err := parseDate(*from)
+	if err != nil {
+		return nil, err
+	}
+
+	toDate, err := parseDate(*to)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Println("=======>>>>>", s)
-}
\ No newline at end of file
+	return c.svc.SpendingSeries(types.Period(*period), types.GroupBy(*by), fromDate, toDate)
+}
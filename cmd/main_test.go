@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+	"github.com/anonimous-arn/wallet/pkg/wallet"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	runCommand := func(code int, args ...string) string {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		got := run(append([]string{"-data", dir, "-json"}, args...), nil, stdout, stderr)
+		if got != code {
+			t.Fatalf("run(%v): code = %v, want %v, stderr = %v", args, got, code, stderr.String())
+		}
+
+		return stdout.String()
+	}
+
+	runCommand(0, "register", "+992900000001")
+	runCommand(0, "deposit", "1", "10.00")
+
+	payment := types.Payment{}
+	err := json.Unmarshal([]byte(runCommand(0, "pay", "1", "3.00", "auto")), &payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered := []types.Payment{}
+	err = json.Unmarshal([]byte(runCommand(0, "filter", "category=auto", "and", "amount>=3.00")), &filtered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(filtered) != 1 || filtered[0].ID != payment.ID {
+		t.Errorf("filter: got %v", filtered)
+	}
+
+	runCommand(1, "filter", "category>auto")
+	runCommand(0, "freeze", "1")
+	runCommand(1, "pay", "1", "1.00", "auto")
+	runCommand(0, "unfreeze", "1")
+	runCommand(0, "pay-phone", "1", "+992988000022", "0.50")
+	runCommand(1, "pay-phone", "1", "+99298", "0.50")
+	runCommand(0, "change-phone", "1", "+992988000011")
+	runCommand(1, "change-phone", "1", "+992988000011")
+
+	changes := []types.PhoneChange{}
+	err = json.Unmarshal([]byte(runCommand(0, "phone-history", "1")), &changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 1 || changes[0].OldPhone != "+992900000001" {
+		t.Errorf("phone-history: got %v", changes)
+	}
+
+	runCommand(0, "register", "+992988000033")
+	runCommand(1, "merge", "1", "1")
+	runCommand(0, "merge", "1", "2")
+	runCommand(1, "phone-history", "2")
+
+	runCommand(0, "reject", payment.ID)
+	runCommand(1, "pay", "1", "50 TJS", "auto")
+	runCommand(2, "pay", "1")
+	runCommand(2, "unknown")
+
+	history := []types.Payment{}
+	err = json.Unmarshal([]byte(runCommand(0, "history", "1")), &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].Status != types.PaymentStatusFail {
+		t.Errorf("history: got %v", history)
+	}
+
+	report := types.AggregateReport{}
+	err = json.Unmarshal([]byte(runCommand(0, "aggregate", "-by", "status")), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rejected payment is counted only by status
+	if len(report.Groups) != 1 || report.Groups[0].Key != string(types.PaymentStatusFail) || report.Count != 1 {
+		t.Errorf("aggregate: got %v", report)
+	}
+
+	runCommand(1, "aggregate", "-by", "phone")
+
+	top := []types.TopEntry{}
+	err = json.Unmarshal([]byte(runCommand(0, "top", "-n", "3", "categories")), &top)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rejected payment isn't ranked
+	if len(top) != 0 {
+		t.Errorf("top: got %v", top)
+	}
+
+	runCommand(2, "top", "phones")
+
+	spending := types.SpendingReport{}
+	err = json.Unmarshal([]byte(runCommand(0, "spending", "-period", "day", "-by", "category")), &spending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(spending.Series) != 1 || len(spending.Series[0].Points) != 1 {
+		t.Errorf("spending: got %v", spending)
+	}
+
+	runCommand(2, "spending", "-from", "yesterday")
+}
+
+func TestRun_money(t *testing.T) {
+	dir := t.TempDir()
+	human := t.TempDir()
+
+	err := ioutil.WriteFile(human+"/accounts.dump", []byte("1;+992900000001;1,234.50 TJS;ACTIVE\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runCommand := func(code int, args ...string) string {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		got := run(append([]string{"-data", dir, "-locale", "en"}, args...), nil, stdout, stderr)
+		if got != code {
+			t.Fatalf("run(%v): code = %v, want %v, stderr = %v", args, got, code, stderr.String())
+		}
+
+		return stdout.String()
+	}
+
+	runCommand(1, "import", human)
+	runCommand(0, "import", "-human", human)
+	runCommand(2, "deposit", "1", "10.505 TJS")
+	// 1050 could be 10.50 or 1,050.00
+	runCommand(2, "deposit", "1", "1050")
+	runCommand(2, "deposit", "1", "12,34 TJS")
+
+	runCommand(0, "deposit", "1", "5.25")
+	output := runCommand(0, "deposit", "1", "5.25 TJS")
+	if output != "account 1  phone +992900000001  balance 1,245.00 TJS  status ACTIVE\n" {
+		t.Errorf("deposit: got %q", output)
+	}
+
+	code := run([]string{"-data", dir, "-locale", "fr", "sum"}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if code != 2 {
+		t.Errorf("run(): code = %v", code)
+	}
+}
+
+func TestRun_shell(t *testing.T) {
+	dir := t.TempDir()
+
+	input := strings.Join([]string{
+		"register +992900000001",
+		"deposit 1 10.00",
+		"y",
+		"pay 1 3.00 auto",
+		"n",
+		"pay 1 3.00 auto",
+		"yes",
+		"find +992900000001",
+		"history",
+		"!2",
+		"y",
+		"exit",
+	}, "\n")
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run([]string{"-data", dir, "shell"}, strings.NewReader(input), stdout, stderr)
+	if code != 0 {
+		t.Fatalf("shell: code = %v, stderr = %v", code, stderr.String())
+	}
+
+	output := stdout.String()
+	for _, want := range []string{
+		"cancelled",
+		"account 1  phone +992900000001  balance 7.00 TJS",
+		"   2  deposit 1 10.00",
+		"balance 17.00 TJS",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("shell: output must contain %q, output = %v", want, output)
+		}
+	}
+
+	stdout.Reset()
+	code = run([]string{"-data", dir, "-json", "history", "1"}, nil, stdout, stderr)
+	if code != 0 || strings.Count(stdout.String(), `"id"`) != 1 {
+		t.Errorf("shell: changes must be saved, history = %v", stdout.String())
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	sh := &shell{c: &cli{svc: &wallet.Service{}}}
+	for _, phone := range []types.Phone{"+992900000001", "+992900000002"} {
+		_, err := sh.c.svc.RegisterAccount(phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Tab after "acc" completes command, then phones are completed to common prefix and listed,
+	// Ctrl-C drops line with variants of "pay" and arrow is skipped
+	input := "acc\t+\t\t1\x7f2\r" + "pay\t\x03x\x1b[A\r" + "\x04"
+	out := &bytes.Buffer{}
+	editor := &lineEditor{in: bufio.NewReader(strings.NewReader(input)), out: out, complete: sh.completions}
+
+	lines := []string{}
+	for {
+		line, err := editor.readLine("> ")
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 || lines[0] != "account +992900000002" || lines[1] != "x" {
+		t.Errorf("readLine(): lines = %q", lines)
+	}
+
+	if !strings.Contains(out.String(), "\r\n+992900000001  +992900000002\r\n> account +99290000000") {
+		t.Errorf("readLine(): completions must be listed, output = %q", out.String())
+	}
+}
+
+func TestRun_audit(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, args := range [][]string{
+		{"-data", dir, "register", "+992900000001"},
+		{"-data", dir, "deposit", "1", "10.00"},
+		{"-data", dir, "-actor", "operator", "deposit", "1", "10.00"},
+	} {
+		stderr := &bytes.Buffer{}
+		code := run(args, nil, &bytes.Buffer{}, stderr)
+		if code != 0 {
+			t.Fatalf("run(%v): code = %v, stderr = %v", args, code, stderr.String())
+		}
+	}
+
+	data, err := ioutil.ReadFile(dir + "/audit.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []types.AuditRecord{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		record := types.AuditRecord{}
+		err = json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	// loading of data directory before command isn't written
+	if len(records) != 2 || records[0].Operation != "deposit" || records[0].Actor != wallet.DefaultActor ||
+		records[1].Operation != "deposit" || records[1].Actor != "operator" {
+		t.Errorf("audit log: got %v", records)
+	}
+}
+
+func TestRun_duplicatePhones(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992988000011;0;ACTIVE\n2;992988000011;0;ACTIVE\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, warning := range []bool{true, false} {
+		stderr := &bytes.Buffer{}
+		code := run([]string{"-data", dir, "merge", "1", "2"}, nil, &bytes.Buffer{}, stderr)
+		if warning && (code != 0 || !strings.Contains(stderr.String(), "warning: phone already registered")) {
+			t.Errorf("merge: duplicates must be loaded with warning, code = %v, stderr = %v", code, stderr.String())
+		}
+		// merged account is gone and there are no conflicts
+		if !warning && (code != 1 || strings.Contains(stderr.String(), "warning")) {
+			t.Errorf("merge: code = %v, stderr = %v", code, stderr.String())
+		}
+	}
+}
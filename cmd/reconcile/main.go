@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/anonimous-arn/wallet/pkg/wallet"
+)
+
+// reconcile loads dumps from directory, checks them and prints report as JSON.
+// Exit code is 1 when discrepancies are found and 2 when dumps can't be loaded.
+func main() {
+	dir := flag.String("dir", "data", "directory with dump files")
+	flag.Parse()
+
+	var svc wallet.Service
+
+	// opening entries would hide balances which don't match journal
+	err := svc.ImportRaw(*dir)
+	if err != nil {
+		log.Printf("can't import %s, error => %v", *dir, err)
+		os.Exit(2)
+	}
+
+	report := svc.Reconcile()
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+
+	if len(report.Discrepancies) != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/server"
+	"github.com/anonimous-arn/wallet/pkg/wallet"
+	"github.com/anonimous-arn/wallet/pkg/webhook"
+)
+
+func main() {
+	addr := flag.String("addr", ":9999", "address to listen")
+	dir := flag.String("data", "data", "directory with dump files")
+	auditPath := flag.String("audit", "", "audit log, default is audit.log in data directory")
+	webhooks := flag.String("webhooks", "", "comma separated URLs which get payment events")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("WALLET_WEBHOOK_SECRET"), "secret of webhook signatures, default is $WALLET_WEBHOOK_SECRET")
+	webhookStore := flag.String("webhook-store", "", "file of undelivered webhooks, default is webhooks.dump in data directory")
+	saveInterval := flag.Duration("save", 10*time.Second, "how often changes are saved to data directory")
+	flag.Parse()
+
+	if *auditPath == "" {
+		*auditPath = *dir + "/audit.log"
+	}
+
+	auditLog, err := wallet.OpenAuditLog(*auditPath)
+	if err != nil {
+		log.Fatalf("can't open audit log %s, error => %v", *auditPath, err)
+	}
+	defer func() {
+		err := auditLog.Close()
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	// loading of state on start isn't written to audit log
+	svc := &wallet.Service{}
+	err = svc.Import(*dir)
+	conflict := &wallet.PhoneConflictError{}
+	if errors.As(err, &conflict) {
+		// accounts of old dumps with the same phone are loaded, so they can be merged
+		log.Printf("warning: %v, merge duplicate accounts", err)
+	} else if err != nil {
+		log.Fatalf("can't import %s, error => %v", *dir, err)
+	}
+	svc.SetAuditLog(auditLog)
+
+	// webhooks are sent by worker of dispatcher, so requests don't wait for merchants
+	var listening chan struct{}
+	if *webhooks != "" {
+		if *webhookStore == "" {
+			*webhookStore = *dir + "/webhooks.dump"
+		}
+
+		dispatcher := webhook.NewDispatcher(*webhookSecret, *webhookStore)
+		for _, url := range strings.Split(*webhooks, ",") {
+			dispatcher.Register(strings.TrimSpace(url))
+		}
+
+		subscription := svc.Subscribe(100, wallet.BackpressureBlock, webhook.PaymentEvents...)
+		defer func() {
+			subscription.Unsubscribe()
+			<-listening
+		}()
+
+		listening = make(chan struct{})
+		go func() {
+			defer close(listening)
+			dispatcher.Listen(subscription)
+		}()
+
+		go func() {
+			for range time.Tick(time.Minute) {
+				remaining, err := dispatcher.RetryUndelivered()
+				if err != nil {
+					log.Print(err)
+				}
+				if remaining != 0 {
+					log.Printf("%d webhooks are still undelivered", remaining)
+				}
+			}
+		}()
+	}
+
+	api := server.New(svc, *dir)
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           api,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	// changes are saved periodically too, so they aren't lost when process is killed
+	go func() {
+		for range time.Tick(*saveInterval) {
+			err := api.ExportChanged()
+			if err != nil {
+				log.Printf("can't export %s, error => %v", *dir, err)
+			}
+		}
+	}()
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			count, err := api.ExpireClaims()
+			if err != nil {
+				log.Print(err)
+			}
+			if count != 0 {
+				log.Printf("%d expired transfers returned to senders", count)
+			}
+		}
+	}()
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := httpServer.Shutdown(ctx)
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	log.Printf("listening on %s", *addr)
+	err = httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	err = api.Export()
+	if err != nil {
+		log.Fatalf("can't export %s, error => %v", *dir, err)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+const shellUsage = `commands of shell:
+  find <phone>                       find account by phone
+  account <account>                  show account
+  payments <account>                 list payments of account
+  payment <payment>                  show payment
+  save-history <account> <dir> [records]
+                                     export payments of account to dir
+  history                            list entered commands, !n runs command n again
+  help                               show this help
+  exit                               leave shell
+and every command of wallet: deposit, pay, pay-phone, expire-claims, reject, repeat, pay-favorite, close,
+merge and import ask confirmation. Tab completes commands and ids or phones of accounts,
+payments and favorites.
+`
+
+// historyFile keeps entered commands between sessions, it is placed into data directory.
+const historyFile = ".shell_history"
+
+var moneyCommands = map[string]bool{
+	"deposit":       true,
+	"pay":           true,
+	"pay-phone":     true,
+	"expire-claims": true,
+	"reject":        true,
+	"repeat":        true,
+	"pay-favorite":  true,
+	"close":         true,
+	"merge":         true,
+	"import":        true,
+}
+
+type shell struct {
+	c       *cli
+	in      lineReader
+	out     io.Writer
+	history []string
+}
+
+// shell runs interactive session reading commands from stdin. Changes are saved after every command.
+func (c *cli) shell(args []string) (interface{}, error) {
+	err := needArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sh := &shell{c: c, out: c.stdout}
+	sh.in = newLineReader(c.stdin, c.stdout, sh.completions)
+	sh.loadHistory()
+
+	fmt.Fprintln(sh.out, `wallet shell, type "help" for commands`)
+	for {
+		line, err := sh.in.readLine("wallet> ")
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			index, err := strconv.Atoi(line[1:])
+			if err != nil || index < 1 || index > len(sh.history) {
+				fmt.Fprintf(sh.out, "no command %s in history\n", line)
+				continue
+			}
+
+			line = sh.history[index-1]
+			fmt.Fprintln(sh.out, line)
+		}
+
+		sh.addHistory(line)
+
+		fields := strings.Fields(line)
+		if fields[0] == "exit" || fields[0] == "quit" {
+			break
+		}
+
+		err = sh.execute(fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+	}
+
+	return map[string]string{"shell": "bye"}, nil
+}
+
+func (sh *shell) execute(name string, args []string) error {
+	switch name {
+	case "help":
+		fmt.Fprint(sh.out, shellUsage)
+		return nil
+	case "history":
+		if len(args) != 0 {
+			break
+		}
+
+		for i, line := range sh.history {
+			fmt.Fprintf(sh.out, "%4d  %s\n", i+1, line)
+		}
+		return nil
+	case "find":
+		err := needArgs(args, 1)
+		if err != nil {
+			return err
+		}
+
+		account, err := sh.c.svc.FindAccountByPhone(types.Phone(args[0]))
+		if err != nil {
+			return err
+		}
+		return sh.c.print(account)
+	case "account":
+		err := needArgs(args, 1)
+		if err != nil {
+			return err
+		}
+
+		id, err := parseAccountID(args[0])
+		if err != nil {
+			return err
+		}
+
+		account, err := sh.c.svc.FindAccountByID(id)
+		if err != nil {
+			return err
+		}
+		return sh.c.print(account)
+	case "payments":
+		name = "history"
+	case "payment":
+		err := needArgs(args, 1)
+		if err != nil {
+			return err
+		}
+
+		payment, err := sh.c.svc.FindPaymentByID(args[0])
+		if err != nil {
+			return err
+		}
+		return sh.c.print(payment)
+	case "save-history":
+		return sh.saveHistory(args)
+	case "shell":
+		return errors.New("already in shell")
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q, type \"help\"", name)
+	}
+
+	if moneyCommands[name] && !sh.confirm(name+" "+strings.Join(args, " ")) {
+		fmt.Fprintln(sh.out, "cancelled")
+		return nil
+	}
+
+	result, err := cmd.run(sh.c, args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.changes {
+		err = sh.c.save()
+		if err != nil {
+			return err
+		}
+	}
+
+	return sh.c.print(result)
+}
+
+func (sh *shell) confirm(action string) bool {
+	answer, err := sh.in.readLine(action + " - are you sure? [y/N] ")
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func (sh *shell) saveHistory(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("%w: need account, dir and optional records", errUsage)
+	}
+
+	id, err := parseAccountID(args[0])
+	if err != nil {
+		return err
+	}
+
+	records := 100
+	if len(args) == 3 {
+		records, err = strconv.Atoi(args[2])
+		if err != nil || records <= 0 {
+			return fmt.Errorf("%w: bad records %q", errUsage, args[2])
+		}
+	}
+
+	payments, err := sh.c.svc.ExportAccountHistory(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(args[1], 0755)
+	if err != nil {
+		return err
+	}
+
+	err = sh.c.svc.HistoryToFiles(payments, args[1], records)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "%d payments saved to %s\n", len(payments), args[1])
+	return nil
+}
+
+// completions returns variants for the last word of line, they are completed by Tab: names
+// of commands for the first word and ids or phones of accounts, payments and favorites for others.
+func (sh *shell) completions(line string) []string {
+	fields := strings.Fields(line)
+	prefix := ""
+	if len(fields) != 0 && !strings.HasSuffix(line, " ") {
+		prefix = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+
+	candidates := []string{}
+	if len(fields) == 0 {
+		candidates = append(candidates, "help", "history", "find", "account", "payments", "payment", "save-history", "exit")
+		for name := range commands {
+			if name != "shell" {
+				candidates = append(candidates, name)
+			}
+		}
+	} else {
+		for _, account := range sh.c.svc.Accounts() {
+			candidates = append(candidates, strconv.FormatInt(account.ID, 10), string(account.Phone))
+		}
+		for _, payment := range sh.c.svc.Payments() {
+			candidates = append(candidates, payment.ID)
+		}
+		for _, favorite := range sh.c.svc.Favorites() {
+			candidates = append(candidates, favorite.ID)
+		}
+	}
+
+	result := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			result = append(result, candidate)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func (sh *shell) historyPath() string {
+	return sh.c.dataDir + "/" + historyFile
+}
+
+func (sh *shell) loadHistory() {
+	data, err := ioutil.ReadFile(sh.historyPath())
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			sh.history = append(sh.history, line)
+		}
+	}
+}
+
+func (sh *shell) addHistory(line string) {
+	sh.history = append(sh.history, line)
+
+	err := os.MkdirAll(sh.c.dataDir, 0755)
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(sh.historyPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintln(file, line)
+}
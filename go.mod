@@ -2,4 +2,7 @@ module github.com/anonimous-arn/wallet
 
 go 1.16
 
-require github.com/google/uuid v1.2.0
+require (
+	github.com/google/uuid v1.2.0
+	golang.org/x/term v0.0.0-20210503060354-a79de5458b56
+)
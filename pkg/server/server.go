@@ -0,0 +1,752 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+	"github.com/anonimous-arn/wallet/pkg/wallet"
+)
+
+var errBadRequest = errors.New("bad request")
+var errNotFound = errors.New("not found")
+var errMethodNotAllowed = errors.New("method not allowed")
+var errBodyTooLarge = errors.New("request body too large")
+
+// MaxBodySize is limit of request body, requests of API are small JSON objects.
+const MaxBodySize = 64 * 1024
+
+// ActorHeader is header of request with who makes it, it is written to audit log.
+// Requests without it are written with wallet.DefaultActor.
+const ActorHeader = "X-Wallet-Actor"
+
+// Server exposes wallet.Service as JSON API. Service isn't safe for
+// concurrent use, so every request holds mutex of server. Body of request
+// is read before mutex is taken, so slow clients don't hold other requests.
+type Server struct {
+	mu      sync.Mutex
+	svc     *wallet.Service
+	dataDir string
+	mux     *http.ServeMux
+	changed bool
+}
+
+// New returns server for service, dataDir is used by export endpoint.
+func New(svc *wallet.Service, dataDir string) *Server {
+	server := &Server{svc: svc, dataDir: dataDir, mux: http.NewServeMux()}
+	server.mux.HandleFunc("/accounts", server.handleAccounts)
+	server.mux.HandleFunc("/accounts/", server.handleAccount)
+	server.mux.HandleFunc("/payments", server.handlePayments)
+	server.mux.HandleFunc("/payments/", server.handlePayment)
+	server.mux.HandleFunc("/favorites/", server.handleFavorite)
+	server.mux.HandleFunc("/transfers", server.handleTransfers)
+	server.mux.HandleFunc("/transfers/", server.handleTransfer)
+	server.mux.HandleFunc("/export", server.handleExport)
+	server.mux.HandleFunc("/reports/", server.handleReport)
+	return server
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		if len(body) >= MaxBodySize {
+			writeError(w, errBodyTooLarge)
+		} else {
+			writeError(w, errBadRequest)
+		}
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.svc.SetActor(r.Header.Get(ActorHeader))
+	defer s.svc.SetActor("")
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.mux.ServeHTTP(recorder, r)
+
+	// only POST requests change service, export saves it itself
+	if r.Method == http.MethodPost && recorder.status < 300 && r.URL.Path != "/export" {
+		s.changed = true
+	}
+}
+
+// statusRecorder keeps status of response, ServeHTTP checks by it whether request changed service.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// statusOf maps errors of wallet to HTTP status and code of error body.
+func statusOf(err error) (int, string) {
+	var merged *wallet.AccountMergedError
+
+	switch {
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest, "bad_request"
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed, "method_not_allowed"
+	case errors.Is(err, errBodyTooLarge):
+		return http.StatusRequestEntityTooLarge, "body_too_large"
+	case errors.Is(err, wallet.ErrBadQuery), errors.Is(err, wallet.ErrBadCursor), errors.Is(err, wallet.ErrBadExpression):
+		return http.StatusBadRequest, "bad_query"
+	case errors.Is(err, wallet.ErrReservedCharacter):
+		return http.StatusBadRequest, "reserved_character"
+	case errors.Is(err, wallet.ErrInvalidPhone):
+		return http.StatusBadRequest, "invalid_phone"
+	case errors.Is(err, wallet.ErrTransferToSelf):
+		return http.StatusBadRequest, "transfer_to_self"
+	case errors.Is(err, wallet.ErrMergeToSelf):
+		return http.StatusBadRequest, "merge_to_self"
+	case errors.Is(err, wallet.ErrAmountMustBePositive):
+		return http.StatusBadRequest, "amount_must_be_positive"
+	case errors.As(err, &merged):
+		return http.StatusNotFound, "account_merged"
+	case errors.Is(err, wallet.ErrAccountNotFound):
+		return http.StatusNotFound, "account_not_found"
+	case errors.Is(err, wallet.ErrPaymentNotFound):
+		return http.StatusNotFound, "payment_not_found"
+	case errors.Is(err, wallet.ErrFavoriteNotFound):
+		return http.StatusNotFound, "favorite_not_found"
+	case errors.Is(err, wallet.ErrTransferNotFound):
+		return http.StatusNotFound, "transfer_not_found"
+	case errors.Is(err, wallet.ErrPhoneRegistered):
+		return http.StatusConflict, "phone_registered"
+	case errors.Is(err, wallet.ErrAccountFrozen):
+		return http.StatusForbidden, "account_frozen"
+	case errors.Is(err, wallet.ErrAccountClosed):
+		return http.StatusForbidden, "account_closed"
+	case errors.Is(err, wallet.ErrAccountHasBalance):
+		return http.StatusConflict, "account_has_balance"
+	case errors.Is(err, wallet.ErrNotEnoughBalance):
+		return http.StatusUnprocessableEntity, "not_enough_balance"
+	case errors.Is(err, types.ErrOverflow):
+		return http.StatusUnprocessableEntity, "overflow"
+	}
+
+	return http.StatusInternalServerError, "internal_error"
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, code := statusOf(err)
+	if status == http.StatusInternalServerError {
+		log.Print(err)
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error(), Code: code})
+}
+
+func readJSON(r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(value)
+	if err != nil {
+		return errBadRequest
+	}
+
+	return nil
+}
+
+// route splits path after prefix into id and action: /payments/{id}/{action}.
+func route(path string, prefix string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+func (s *Server) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Phone types.Phone `json:"phone"`
+	}
+	err := readJSON(r, &request)
+	if err != nil || request.Phone == "" {
+		writeError(w, errBadRequest)
+		return
+	}
+
+	account, err := s.svc.RegisterAccount(request.Phone)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, account)
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	rawID, action := route(r.URL.Path, "/accounts/")
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		writeError(w, errNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		account, err := s.svc.FindAccountByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, account)
+	case action == "deposit" && r.Method == http.MethodPost:
+		var request struct {
+			Amount types.Money `json:"amount"`
+			Source string      `json:"source"`
+		}
+		err = readJSON(r, &request)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if request.Source == "" {
+			request.Source = wallet.DefaultDepositSource
+		}
+
+		_, err = s.svc.DepositFrom(id, request.Amount, request.Source)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		account, err := s.svc.FindAccountByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, account)
+	case action == "history" && r.Method == http.MethodGet:
+		payments, err := s.svc.ExportAccountHistory(id)
+		if errors.Is(err, wallet.ErrPaymentNotFound) {
+			payments, err = []types.Payment{}, nil
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, payments)
+	case action == "phone" && r.Method == http.MethodPost:
+		var request struct {
+			Phone types.Phone `json:"phone"`
+		}
+		err = readJSON(r, &request)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		_, err = s.svc.ChangePhone(id, request.Phone)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		account, err := s.svc.FindAccountByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, account)
+	case action == "phones" && r.Method == http.MethodGet:
+		changes, err := s.svc.PhoneHistory(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, changes)
+	case action == "merge" && r.Method == http.MethodPost:
+		var request struct {
+			AccountID int64 `json:"account_id"`
+		}
+		err = readJSON(r, &request)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		merge, err := s.svc.MergeAccounts(id, request.AccountID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, merge)
+	case (action == "freeze" || action == "unfreeze" || action == "close") && r.Method == http.MethodPost:
+		change := map[string]func(int64) error{"freeze": s.svc.Freeze, "unfreeze": s.svc.Unfreeze, "close": s.svc.Close}[action]
+		err = change(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		account, err := s.svc.FindAccountByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, account)
+	case action == "" || action == "deposit" || action == "history" || action == "freeze" || action == "unfreeze" ||
+		action == "close" || action == "phone" || action == "phones" || action == "merge":
+		writeError(w, errMethodNotAllowed)
+	default:
+		writeError(w, errNotFound)
+	}
+}
+
+func (s *Server) handlePayments(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.queryPayments(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		AccountID int64                 `json:"account_id"`
+		Amount    types.Money           `json:"amount"`
+		Category  types.PaymentCategory `json:"category"`
+	}
+	err := readJSON(r, &request)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	payment, err := s.svc.Pay(request.AccountID, request.Amount, request.Category)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, payment)
+}
+
+func (s *Server) handlePayment(w http.ResponseWriter, r *http.Request) {
+	id, action := route(r.URL.Path, "/payments/")
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		payment, err := s.svc.FindPaymentByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, payment)
+	case action == "reject" && r.Method == http.MethodPost:
+		err := s.svc.Reject(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		payment, err := s.svc.FindPaymentByID(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, payment)
+	case action == "repeat" && r.Method == http.MethodPost:
+		payment, err := s.svc.Repeat(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, payment)
+	case action == "favorite" && r.Method == http.MethodPost:
+		var request struct {
+			Name string `json:"name"`
+		}
+		err := readJSON(r, &request)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		favorite, err := s.svc.FavoritePayment(id, request.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, favorite)
+	case action == "" || action == "reject" || action == "repeat" || action == "favorite":
+		writeError(w, errMethodNotAllowed)
+	default:
+		writeError(w, errNotFound)
+	}
+}
+
+func (s *Server) handleFavorite(w http.ResponseWriter, r *http.Request) {
+	id, action := route(r.URL.Path, "/favorites/")
+	if action != "pay" {
+		writeError(w, errNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	payment, err := s.svc.PayFromFavorite(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, payment)
+}
+
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	err := s.svc.ExportContext(r.Context(), s.dataDir)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.changed = false
+
+	writeJSON(w, http.StatusOK, map[string]string{"dir": s.dataDir})
+}
+
+func (s *Server) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		FromAccountID int64       `json:"from_account_id"`
+		Phone         types.Phone `json:"phone"`
+		Amount        types.Money `json:"amount"`
+	}
+	err := readJSON(r, &request)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	transfer, err := s.svc.PayToPhone(request.FromAccountID, request.Phone, request.Amount)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, transfer)
+}
+
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	id, action := route(r.URL.Path, "/transfers/")
+	if action != "" {
+		writeError(w, errNotFound)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	transfer, err := s.svc.FindTransferByID(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, transfer)
+}
+
+// ExpireClaims returns expired transfers to senders, it is called periodically.
+func (s *Server) ExpireClaims() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, err := s.svc.ExpireClaims()
+	if count != 0 {
+		s.changed = true
+	}
+
+	return count, err
+}
+
+// Export saves service to data directory, it is used on shutdown.
+func (s *Server) Export() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.export()
+}
+
+// ExportChanged saves service to data directory when it was changed since last save,
+// it is called periodically, so changes aren't lost when process stops without shutdown.
+func (s *Server) ExportChanged() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.changed {
+		return nil
+	}
+
+	return s.export()
+}
+
+func (s *Server) export() error {
+	err := s.svc.Export(s.dataDir)
+	if err != nil {
+		return err
+	}
+
+	s.changed = false
+	return nil
+}
+
+// queryPayments handles GET /payments?account=1&category=auto&status=OK&min_amount=&max_amount=
+// &from=&to=&id_prefix=&where=&sort=time&desc=true&limit=50&cursor=, category and status can be repeated,
+// where is expression of wallet.ParsePredicate.
+func (s *Server) queryPayments(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query := types.PaymentQuery{
+		IDPrefix: values.Get("id_prefix"),
+		Where:    values.Get("where"),
+		SortBy:   types.PaymentSortField(values.Get("sort")),
+		Cursor:   values.Get("cursor"),
+	}
+
+	for _, category := range values["category"] {
+		query.Categories = append(query.Categories, types.PaymentCategory(category))
+	}
+
+	for _, status := range values["status"] {
+		query.Statuses = append(query.Statuses, types.PaymentStatus(status))
+	}
+
+	numbers := map[string]*int64{
+		"account":    &query.AccountID,
+		"min_amount": (*int64)(&query.MinAmount),
+		"max_amount": (*int64)(&query.MaxAmount),
+		"from":       &query.From,
+		"to":         &query.To,
+	}
+	for name, value := range numbers {
+		if values.Get(name) == "" {
+			continue
+		}
+
+		number, err := strconv.ParseInt(values.Get(name), 10, 64)
+		if err != nil {
+			writeError(w, errBadRequest)
+			return
+		}
+		*value = number
+	}
+
+	if values.Get("limit") != "" {
+		limit, err := strconv.Atoi(values.Get("limit"))
+		if err != nil {
+			writeError(w, errBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+
+	if values.Get("desc") != "" {
+		desc, err := strconv.ParseBool(values.Get("desc"))
+		if err != nil {
+			writeError(w, errBadRequest)
+			return
+		}
+		query.Desc = desc
+	}
+
+	page, err := s.svc.QueryPayments(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleReport handles GET /reports/aggregate?by=category&where=&format=csv, format is json by default,
+// GET /reports/top?by=accounts&n=10&where=, by is accounts, categories or payments,
+// and GET /reports/spending?period=month&by=category&from=&to=&where=, from and to are unix time.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	name, action := route(r.URL.Path, "/reports/")
+	if (name != "aggregate" && name != "top" && name != "spending") || action != "" {
+		writeError(w, errNotFound)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	values := r.URL.Query()
+	var filter wallet.Predicate
+	if values.Get("where") != "" {
+		predicate, err := wallet.ParsePredicate(values.Get("where"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		filter = predicate
+	}
+
+	if name == "top" {
+		s.top(w, r, filter)
+		return
+	}
+
+	if name == "spending" {
+		s.spending(w, r, filter)
+		return
+	}
+
+	by := types.GroupBy(values.Get("by"))
+	if by == "" {
+		by = types.GroupByCategory
+	}
+
+	report, err := s.svc.AggregatePaymentsContext(r.Context(), by, filter, runtime.GOMAXPROCS(0))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	switch values.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, report)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		err = wallet.WriteAggregateCSV(w, report)
+		if err != nil {
+			log.Print(err)
+		}
+	default:
+		writeError(w, errBadRequest)
+	}
+}
+
+func (s *Server) top(w http.ResponseWriter, r *http.Request, filter wallet.Predicate) {
+	values := r.URL.Query()
+	n := 10
+	if values.Get("n") != "" {
+		number, err := strconv.Atoi(values.Get("n"))
+		if err != nil {
+			writeError(w, errBadRequest)
+			return
+		}
+		n = number
+	}
+
+	var result interface{}
+	var err error
+	goroutines := runtime.GOMAXPROCS(0)
+	switch values.Get("by") {
+	case "", "accounts":
+		result, err = s.svc.TopAccountsContext(r.Context(), n, filter, goroutines)
+	case "categories":
+		result, err = s.svc.TopCategoriesContext(r.Context(), n, filter, goroutines)
+	case "payments":
+		result, err = s.svc.TopPaymentsContext(r.Context(), n, filter, goroutines)
+	default:
+		err = fmt.Errorf("%w: unknown top %q", wallet.ErrBadQuery, values.Get("by"))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) spending(w http.ResponseWriter, r *http.Request, filter wallet.Predicate) {
+	values := r.URL.Query()
+	period := types.Period(values.Get("period"))
+	if period == "" {
+		period = types.PeriodMonth
+	}
+
+	bounds := []time.Time{{}, {}}
+	for i, name := range []string{"from", "to"} {
+		if values.Get(name) == "" {
+			continue
+		}
+
+		unix, err := strconv.ParseInt(values.Get(name), 10, 64)
+		if err != nil {
+			writeError(w, errBadRequest)
+			return
+		}
+		bounds[i] = time.Unix(unix, 0)
+	}
+
+	report, err := s.svc.SpendingSeriesContext(r.Context(), period, types.GroupBy(values.Get("by")), bounds[0], bounds[1], filter, runtime.GOMAXPROCS(0))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+	"github.com/anonimous-arn/wallet/pkg/wallet"
+)
+
+func do(t *testing.T, handler http.Handler, method string, path string, body string, result interface{}) int {
+	request := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if result != nil {
+		err := json.Unmarshal(recorder.Body.Bytes(), result)
+		if err != nil {
+			t.Fatalf("%s %s: can't decode %q, error = %v", method, path, recorder.Body.String(), err)
+		}
+	}
+
+	return recorder.Code
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	handler := New(&wallet.Service{}, dir)
+
+	account := types.Account{}
+	code := do(t, handler, http.MethodPost, "/accounts", `{"phone":"+992900000001"}`, &account)
+	if code != http.StatusCreated || account.ID != 1 {
+		t.Fatalf("register: code = %v, account = %v", code, account)
+	}
+
+	failure := errorResponse{}
+	code = do(t, handler, http.MethodPost, "/accounts", `{"phone":"+992900000001"}`, &failure)
+	if code != http.StatusConflict || failure.Code != "phone_registered" {
+		t.Errorf("register twice: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts", `{"phone":"992 90 000 00 01"}`, &failure)
+	if code != http.StatusConflict || failure.Code != "phone_registered" {
+		t.Errorf("register twice: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts", `{"phone":"+99212"}`, &failure)
+	if code != http.StatusBadRequest || failure.Code != "invalid_phone" {
+		t.Errorf("register: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/1/deposit", `{"amount":1000}`, &account)
+	if code != http.StatusOK || account.Balance != 1000 {
+		t.Fatalf("deposit: code = %v, account = %v", code, account)
+	}
+
+	code = do(t, handler, http.MethodPost, "/payments", `{"account_id":1,"amount":5000,"category":"auto"}`, &failure)
+	if code != http.StatusUnprocessableEntity || failure.Code != "not_enough_balance" {
+		t.Errorf("pay: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/payments", `{"account_id":2,"amount":5000,"category":"auto"}`, &failure)
+	if code != http.StatusNotFound || failure.Code != "account_not_found" {
+		t.Errorf("pay: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/payments", `{"account_id":1,"amount":-1,"category":"auto"}`, &failure)
+	if code != http.StatusBadRequest {
+		t.Errorf("pay: code = %v, error = %v", code, failure)
+	}
+
+	payment := types.Payment{}
+	code = do(t, handler, http.MethodPost, "/payments", `{"account_id":1,"amount":300,"category":"auto"}`, &payment)
+	if code != http.StatusCreated || payment.Amount != 300 {
+		t.Fatalf("pay: code = %v, payment = %v", code, payment)
+	}
+
+	repeated := types.Payment{}
+	code = do(t, handler, http.MethodPost, "/payments/"+payment.ID+"/repeat", ``, &repeated)
+	if code != http.StatusCreated || repeated.ID == payment.ID {
+		t.Errorf("repeat: code = %v, payment = %v", code, repeated)
+	}
+
+	favorite := types.Favorite{}
+	code = do(t, handler, http.MethodPost, "/payments/"+payment.ID+"/favorite", `{"name":"osh"}`, &favorite)
+	if code != http.StatusCreated || favorite.Name != "osh" {
+		t.Fatalf("favorite: code = %v, favorite = %v", code, favorite)
+	}
+
+	code = do(t, handler, http.MethodPost, "/favorites/"+favorite.ID+"/pay", ``, &payment)
+	if code != http.StatusCreated || payment.Amount != 300 {
+		t.Errorf("pay from favorite: code = %v, payment = %v", code, payment)
+	}
+
+	code = do(t, handler, http.MethodPost, "/payments/"+payment.ID+"/reject", ``, &payment)
+	if code != http.StatusOK || payment.Status != types.PaymentStatusFail {
+		t.Errorf("reject: code = %v, payment = %v", code, payment)
+	}
+
+	code = do(t, handler, http.MethodGet, "/payments/unknown", ``, &failure)
+	if code != http.StatusNotFound || failure.Code != "payment_not_found" {
+		t.Errorf("get payment: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/1/freeze", ``, &account)
+	if code != http.StatusOK || account.Status != types.AccountStatusFrozen {
+		t.Errorf("freeze: code = %v, account = %v", code, account)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/1/deposit", `{"amount":1000}`, &failure)
+	if code != http.StatusForbidden || failure.Code != "account_frozen" {
+		t.Errorf("deposit: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/1/close", ``, &failure)
+	if code != http.StatusConflict || failure.Code != "account_has_balance" {
+		t.Errorf("close: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/1/unfreeze", ``, &account)
+	if code != http.StatusOK || account.Status != types.AccountStatusActive {
+		t.Errorf("unfreeze: code = %v, account = %v", code, account)
+	}
+
+	transfer := types.Transfer{}
+	code = do(t, handler, http.MethodPost, "/transfers", `{"from_account_id":1,"phone":"+992988000011","amount":100}`, &transfer)
+	if code != http.StatusCreated || transfer.Status != types.TransferStatusPending {
+		t.Fatalf("transfer: code = %v, transfer = %v", code, transfer)
+	}
+
+	code = do(t, handler, http.MethodGet, "/transfers/"+transfer.ID, ``, &transfer)
+	if code != http.StatusOK || transfer.Amount != 100 {
+		t.Errorf("transfer: code = %v, transfer = %v", code, transfer)
+	}
+
+	code = do(t, handler, http.MethodPost, "/transfers", `{"from_account_id":1,"phone":"+992900000001","amount":100}`, &failure)
+	if code != http.StatusBadRequest || failure.Code != "transfer_to_self" {
+		t.Errorf("transfer: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts", `{"phone":"+992988000011"}`, &account)
+	if code != http.StatusCreated || account.Balance != 100 {
+		t.Errorf("register: code = %v, account = %v", code, account)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/2/phone", `{"phone":"+992900000001"}`, &failure)
+	if code != http.StatusConflict || failure.Code != "phone_registered" {
+		t.Errorf("change phone: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/2/phone", `{"phone":"+992988000099"}`, &account)
+	if code != http.StatusOK || account.Phone != "+992988000099" {
+		t.Errorf("change phone: code = %v, account = %v", code, account)
+	}
+
+	changes := []types.PhoneChange{}
+	code = do(t, handler, http.MethodGet, "/accounts/2/phones", ``, &changes)
+	if code != http.StatusOK || len(changes) != 1 || changes[0].OldPhone != "+992988000011" {
+		t.Errorf("phones: code = %v, changes = %v", code, changes)
+	}
+
+	history := []types.Payment{}
+	code = do(t, handler, http.MethodGet, "/accounts/1/history", ``, &history)
+	if code != http.StatusOK || len(history) != 3 {
+		t.Errorf("history: code = %v, history = %v", code, history)
+	}
+
+	code = do(t, handler, http.MethodGet, "/accounts/1", ``, &account)
+	if code != http.StatusOK || account.Balance != 300 {
+		t.Errorf("account: code = %v, account = %v", code, account)
+	}
+
+	page := types.PaymentPage{}
+	code = do(t, handler, http.MethodGet, "/payments?account=1&status=INPROGRESS&sort=amount&limit=1", ``, &page)
+	if code != http.StatusOK || len(page.Payments) != 1 || page.NextCursor == "" {
+		t.Errorf("query: code = %v, page = %v", code, page)
+	}
+
+	cursor := page.NextCursor
+	page = types.PaymentPage{}
+	code = do(t, handler, http.MethodGet, "/payments?cursor="+cursor+"&account=1&status=INPROGRESS&sort=amount&limit=1", ``, &page)
+	if code != http.StatusOK || len(page.Payments) != 1 || page.NextCursor != "" {
+		t.Errorf("query: code = %v, page = %v", code, page)
+	}
+
+	page = types.PaymentPage{}
+	code = do(t, handler, http.MethodGet, "/payments?where=status%3DFAIL+or+amount%3E3.00", ``, &page)
+	if code != http.StatusOK || len(page.Payments) != 1 || page.Payments[0].Status != types.PaymentStatusFail {
+		t.Errorf("query: code = %v, page = %v", code, page)
+	}
+
+	code = do(t, handler, http.MethodGet, "/payments?where=status", ``, &failure)
+	if code != http.StatusBadRequest || failure.Code != "bad_query" {
+		t.Errorf("query: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodGet, "/payments?sort=phone", ``, &failure)
+	if code != http.StatusBadRequest || failure.Code != "bad_query" {
+		t.Errorf("query: code = %v, error = %v", code, failure)
+	}
+
+	report := types.AggregateReport{}
+	code = do(t, handler, http.MethodGet, "/reports/aggregate?by=account&where=status%3DINPROGRESS", ``, &report)
+	if code != http.StatusOK || len(report.Groups) != 1 || report.Groups[0].Count != 2 || report.Total != 600 {
+		t.Errorf("aggregate: code = %v, report = %v", code, report)
+	}
+
+	code = do(t, handler, http.MethodGet, "/reports/aggregate?by=phone", ``, &failure)
+	if code != http.StatusBadRequest || failure.Code != "bad_query" {
+		t.Errorf("aggregate: code = %v, error = %v", code, failure)
+	}
+
+	top := []types.Payment{}
+	code = do(t, handler, http.MethodGet, "/reports/top?by=payments&n=1&where=status%3DINPROGRESS", ``, &top)
+	if code != http.StatusOK || len(top) != 1 || top[0].Amount != 300 {
+		t.Errorf("top: code = %v, top = %v", code, top)
+	}
+
+	code = do(t, handler, http.MethodGet, "/reports/top?n=0", ``, &failure)
+	if code != http.StatusBadRequest || failure.Code != "bad_query" {
+		t.Errorf("top: code = %v, error = %v", code, failure)
+	}
+
+	spending := types.SpendingReport{}
+	code = do(t, handler, http.MethodGet, "/reports/spending?period=week&by=category", ``, &spending)
+	if code != http.StatusOK || len(spending.Series) != 1 || spending.Series[0].Points[0].Total != 600 {
+		t.Errorf("spending: code = %v, report = %v", code, spending)
+	}
+
+	code = do(t, handler, http.MethodGet, "/reports/spending?period=year", ``, &failure)
+	if code != http.StatusBadRequest || failure.Code != "bad_query" {
+		t.Errorf("spending: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/accounts/1/merge", `{"account_id":1}`, &failure)
+	if code != http.StatusBadRequest || failure.Code != "merge_to_self" {
+		t.Errorf("merge: code = %v, error = %v", code, failure)
+	}
+
+	merge := types.AccountMerge{}
+	code = do(t, handler, http.MethodPost, "/accounts/1/merge", `{"account_id":2}`, &merge)
+	if code != http.StatusOK || merge.IntoAccountID != 1 || merge.Amount != 100 {
+		t.Errorf("merge: code = %v, merge = %v", code, merge)
+	}
+
+	code = do(t, handler, http.MethodGet, "/accounts/2", ``, &failure)
+	if code != http.StatusNotFound || failure.Code != "account_merged" {
+		t.Errorf("account: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodGet, "/export", ``, &failure)
+	if code != http.StatusMethodNotAllowed {
+		t.Errorf("export: code = %v, error = %v", code, failure)
+	}
+
+	code = do(t, handler, http.MethodPost, "/export", ``, nil)
+	if code != http.StatusOK {
+		t.Errorf("export: code = %v", code)
+	}
+
+	_, err := ioutil.ReadFile(dir + "/accounts.dump")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_actor(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := wallet.OpenAuditLog(dir + "/audit.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	svc := &wallet.Service{}
+	svc.SetAuditLog(auditLog)
+	handler := New(svc, dir)
+
+	code := do(t, handler, http.MethodPost, "/accounts", `{"phone":"+992900000001"}`, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("register: code = %v", code)
+	}
+
+	for _, actor := range []string{"operator", ""} {
+		request := httptest.NewRequest(http.MethodPost, "/accounts/1/deposit", bytes.NewBufferString(`{"amount":100}`))
+		request.Header.Set(ActorHeader, actor)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("deposit: code = %v", recorder.Code)
+		}
+	}
+
+	data, err := ioutil.ReadFile(dir + "/audit.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actors := []string{}
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		record := types.AuditRecord{}
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actors = append(actors, record.Actor)
+	}
+
+	// actor of request isn't kept for next request
+	if len(actors) != 2 || actors[0] != "operator" || actors[1] != wallet.DefaultActor {
+		t.Errorf("audit log: actors = %v", actors)
+	}
+}
+
+func TestServer_body(t *testing.T) {
+	handler := New(&wallet.Service{}, t.TempDir())
+
+	failure := errorResponse{}
+	body := `{"phone":"+992900000001","padding":"` + strings.Repeat("x", MaxBodySize) + `"}`
+	code := do(t, handler, http.MethodPost, "/accounts", body, &failure)
+	if code != http.StatusRequestEntityTooLarge || failure.Code != "body_too_large" {
+		t.Errorf("register: code = %v, error = %v", code, failure)
+	}
+
+	// body is read before mutex is taken, so slow client doesn't hold other requests
+	reader, writer := io.Pipe()
+	slow := make(chan int)
+	go func() {
+		request := httptest.NewRequest(http.MethodPost, "/accounts", reader)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		slow <- recorder.Code
+	}()
+
+	done := make(chan int)
+	go func() {
+		done <- do(t, handler, http.MethodPost, "/accounts", `{"phone":"+992900000002"}`, nil)
+	}()
+
+	select {
+	case code = <-done:
+		if code != http.StatusCreated {
+			t.Errorf("register: code = %v", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("register: request waits for slow body of other request")
+	}
+
+	_, err := writer.Write([]byte(`{"phone":"+992900000001"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	code = <-slow
+	if code != http.StatusCreated {
+		t.Errorf("register with slow body: code = %v", code)
+	}
+}
+
+func TestServer_ExportChanged(t *testing.T) {
+	dir := t.TempDir()
+	handler := New(&wallet.Service{}, dir)
+
+	err := handler.ExportChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(dir + "/accounts.dump")
+	if !os.IsNotExist(err) {
+		t.Fatalf("ExportChanged(): service isn't changed, error = %v", err)
+	}
+
+	code := do(t, handler, http.MethodPost, "/accounts", `{"phone":"+992900000001"}`, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("register: code = %v", code)
+	}
+
+	err = handler.ExportChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(dir + "/accounts.dump")
+	if err != nil || !strings.Contains(string(data), "+992900000001") {
+		t.Errorf("ExportChanged(): accounts = %q, error = %v", data, err)
+	}
+}
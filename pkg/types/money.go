@@ -0,0 +1,58 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrOverflow = errors.New("money overflow")
+
+// OverflowError describes operation which doesn't fit into Money, errors.Is(err, ErrOverflow) is true for it.
+type OverflowError struct {
+	Op    string
+	Left  Money
+	Right int64
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("%v: %d %s %d", ErrOverflow, e.Left, e.Op, e.Right)
+}
+
+func (e *OverflowError) Unwrap() error {
+	return ErrOverflow
+}
+
+// Add returns m + other or *OverflowError.
+func (m Money) Add(other Money) (Money, error) {
+	sum := m + other
+	if (other > 0 && sum < m) || (other < 0 && sum > m) {
+		return 0, &OverflowError{Op: "+", Left: m, Right: int64(other)}
+	}
+
+	return sum, nil
+}
+
+// Sub returns m - other or *OverflowError.
+func (m Money) Sub(other Money) (Money, error) {
+	difference := m - other
+	if (other > 0 && difference > m) || (other < 0 && difference < m) {
+		return 0, &OverflowError{Op: "-", Left: m, Right: int64(other)}
+	}
+
+	return difference, nil
+}
+
+// Mul returns m * n or *OverflowError.
+func (m Money) Mul(n int64) (Money, error) {
+	if m == 0 || n == 0 {
+		return 0, nil
+	}
+
+	product := m * Money(n)
+	if (m == -1 && n == math.MinInt64) || (n == -1 && m == math.MinInt64) || int64(product)/n != int64(m) {
+		return 0, &OverflowError{Op: "*", Left: m, Right: n}
+	}
+
+	return product, nil
+}
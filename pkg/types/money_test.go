@@ -0,0 +1,41 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoney_checked(t *testing.T) {
+	tests := []struct {
+		name     string
+		got      func() (Money, error)
+		want     Money
+		overflow bool
+	}{
+		{"add", func() (Money, error) { return Money(100).Add(-300) }, -200, false},
+		{"add max", func() (Money, error) { return Money(math.MaxInt64).Add(1) }, 0, true},
+		{"add min", func() (Money, error) { return Money(math.MinInt64).Add(-1) }, 0, true},
+		{"sub", func() (Money, error) { return Money(100).Sub(300) }, -200, false},
+		{"sub max", func() (Money, error) { return Money(math.MaxInt64).Sub(-1) }, 0, true},
+		{"sub min", func() (Money, error) { return Money(math.MinInt64).Sub(1) }, 0, true},
+		{"sub to min", func() (Money, error) { return Money(-1).Sub(math.MaxInt64) }, math.MinInt64, false},
+		{"mul", func() (Money, error) { return Money(-250).Mul(4) }, -1000, false},
+		{"mul zero", func() (Money, error) { return Money(math.MinInt64).Mul(0) }, 0, false},
+		{"mul max", func() (Money, error) { return Money(math.MaxInt64/2 + 1).Mul(2) }, 0, true},
+		{"mul min", func() (Money, error) { return Money(math.MinInt64).Mul(-1) }, 0, true},
+		{"mul by min", func() (Money, error) { return Money(-1).Mul(math.MinInt64) }, 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := test.got()
+		overflow := &OverflowError{}
+		if test.overflow != (errors.As(err, &overflow) && errors.Is(err, ErrOverflow)) {
+			t.Errorf("%s: error = %v", test.name, err)
+		}
+
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
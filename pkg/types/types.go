@@ -13,28 +13,331 @@ const (
 )
 
 type Payment struct {
-	ID			string
-	AccountID	int64
-	Amount		Money
-	Category	PaymentCategory
-	Status		PaymentStatus
+	ID			string			`json:"id"`
+	AccountID	int64			`json:"account_id"`
+	Amount		Money			`json:"amount"`
+	Category	PaymentCategory	`json:"category"`
+	Status		PaymentStatus	`json:"status"`
+	Time		int64			`json:"time"`
 }
 
 type Phone string
 
+type AccountStatus string
+
+const (
+	AccountStatusActive	AccountStatus = "ACTIVE"
+	AccountStatusFrozen	AccountStatus = "FROZEN"
+	AccountStatusClosed	AccountStatus = "CLOSED"
+)
+
 type Account struct {
-	ID		int64
-	Phone	Phone
-	Balance	Money
+	ID		int64			`json:"id"`
+	Phone	Phone			`json:"phone"`
+	Balance	Money			`json:"balance"`
+	Status	AccountStatus	`json:"status"`
 }
 type Favorite struct {
-	ID			string
-	AccountID	int64
-	Name		string
-	Amount		Money
-	Category	PaymentCategory
+	ID			string			`json:"id"`
+	AccountID	int64			`json:"account_id"`
+	Name		string			`json:"name"`
+	Amount		Money			`json:"amount"`
+	Category	PaymentCategory	`json:"category"`
 }
+// Progress is reported for every summed part of payments. Part is count of payments
+// in part and Result is their sum. Processed, Percent and Sum are totals of parts
+// reported so far, Done is set on the last progress, so its Sum is sum of all payments.
+// Err is set when sum overflows, such progress is the last one.
 type Progress struct {
-	Part   int
-	Result Money
-}
\ No newline at end of file
+	Part      int
+	Result    Money
+	Processed int
+	Total     int
+	Percent   float64
+	Sum       Money
+	Done      bool
+	Err       error
+}
+
+type EntryType string
+
+const (
+	EntryTypeDeposit  EntryType = "DEPOSIT"
+	EntryTypePayment  EntryType = "PAYMENT"
+	EntryTypeRefund   EntryType = "REFUND"
+	EntryTypeOpening  EntryType = "OPENING"
+	EntryTypeTransfer EntryType = "TRANSFER"
+)
+
+// Entry is a single movement of money on an account as seen from its wallet. Amount is positive
+// for credits (deposits, refunds) and negative for debits (payments).
+type Entry struct {
+	ID        string
+	AccountID int64
+	Type      EntryType
+	Amount    Money
+	PaymentID string
+	Source    string
+	Time      int64
+}
+
+// LedgerAccount is name of account in double-entry journal: wallet of customer
+// or one of system accounts (deposits, categories, refunds).
+type LedgerAccount string
+
+// Posting changes balance of one ledger account, positive amounts are debits
+// and negative are credits. Postings of one transaction sum to zero.
+type Posting struct {
+	Account LedgerAccount
+	Amount  Money
+}
+
+type Transaction struct {
+	ID        string
+	Type      EntryType
+	PaymentID string
+	Source    string
+	Time      int64
+	Postings  []Posting
+}
+
+type TrialBalanceLine struct {
+	Account LedgerAccount
+	Debit   Money
+	Credit  Money
+}
+
+type TrialBalance struct {
+	Lines  []TrialBalanceLine
+	Debit  Money
+	Credit Money
+}
+
+type StatementLine struct {
+	Entry
+	Balance Money
+}
+
+type Statement struct {
+	AccountID int64
+	Phone     Phone
+	From      int64
+	To        int64
+	Opening   Money
+	Deposits  Money
+	Payments  Money
+	Refunds   Money
+	Transfers Money
+	Closing   Money
+	Lines     []StatementLine
+}
+
+type DiscrepancyKind string
+
+const (
+	DiscrepancyDuplicateID           DiscrepancyKind = "duplicate_id"
+	DiscrepancyDuplicatePhone        DiscrepancyKind = "duplicate_phone"
+	DiscrepancyOrphanPayment         DiscrepancyKind = "orphan_payment"
+	DiscrepancyOrphanFavorite        DiscrepancyKind = "orphan_favorite"
+	DiscrepancyOrphanPosting         DiscrepancyKind = "orphan_posting"
+	DiscrepancyNegativeBalance       DiscrepancyKind = "negative_balance"
+	DiscrepancyBalanceMismatch       DiscrepancyKind = "balance_mismatch"
+	DiscrepancyUnbalancedTransaction DiscrepancyKind = "unbalanced_transaction"
+	DiscrepancyOverflow              DiscrepancyKind = "overflow"
+)
+
+type Discrepancy struct {
+	Kind    DiscrepancyKind `json:"kind"`
+	Entity  string          `json:"entity"`
+	ID      string          `json:"id"`
+	Message string          `json:"message"`
+}
+
+type ReconcileReport struct {
+	Accounts      int           `json:"accounts"`
+	Payments      int           `json:"payments"`
+	Favorites     int           `json:"favorites"`
+	Transactions  int           `json:"transactions"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+type AuditRecord struct {
+	Seq       int64             `json:"seq"`
+	Time      int64             `json:"time"`
+	Actor     string            `json:"actor"`
+	Operation string            `json:"operation"`
+	Params    map[string]string `json:"params"`
+	Result    string            `json:"result"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+type EventType string
+
+const (
+	EventAccountRegistered EventType = "account.registered"
+	EventAccountFrozen     EventType = "account.frozen"
+	EventAccountUnfrozen   EventType = "account.unfrozen"
+	EventAccountClosed     EventType = "account.closed"
+	EventPhoneChanged      EventType = "account.phone_changed"
+	EventAccountMerged     EventType = "account.merged"
+	EventDeposit           EventType = "account.deposit"
+	EventPaymentCreated    EventType = "payment.created"
+	EventPaymentRejected   EventType = "payment.rejected"
+	EventPaymentRepeated   EventType = "payment.repeated"
+	EventFavoriteCreated   EventType = "favorite.created"
+	EventTransferSent      EventType = "transfer.sent"
+	EventTransferReceived  EventType = "transfer.received"
+	EventTransferRefunded  EventType = "transfer.refunded"
+)
+
+// Event describes change in wallet. Payment and Favorite are copies,
+// so subscribers can read them without locking service.
+type Event struct {
+	Type      EventType `json:"type"`
+	Time      int64     `json:"time"`
+	AccountID int64     `json:"account_id"`
+	Amount    Money     `json:"amount,omitempty"`
+	Payment   *Payment  `json:"payment,omitempty"`
+	Favorite  *Favorite `json:"favorite,omitempty"`
+	// OriginalPaymentID is set for repeated payments.
+	OriginalPaymentID string `json:"original_payment_id,omitempty"`
+}
+
+type PaymentSortField string
+
+const (
+	SortByTime   PaymentSortField = "time"
+	SortByAmount PaymentSortField = "amount"
+	SortByID     PaymentSortField = "id"
+)
+
+// PaymentQuery selects payments. Zero values of fields don't restrict result,
+// To is exclusive. Cursor is NextCursor of previous page.
+type PaymentQuery struct {
+	AccountID  int64             `json:"account_id,omitempty"`
+	Categories []PaymentCategory `json:"categories,omitempty"`
+	Statuses   []PaymentStatus   `json:"statuses,omitempty"`
+	MinAmount  Money             `json:"min_amount,omitempty"`
+	MaxAmount  Money             `json:"max_amount,omitempty"`
+	From       int64             `json:"from,omitempty"`
+	To         int64             `json:"to,omitempty"`
+	IDPrefix   string            `json:"id_prefix,omitempty"`
+	Where      string            `json:"where,omitempty"`
+	SortBy     PaymentSortField  `json:"sort_by,omitempty"`
+	Desc       bool              `json:"desc,omitempty"`
+	Limit      int               `json:"limit,omitempty"`
+	Cursor     string            `json:"cursor,omitempty"`
+}
+
+type PaymentPage struct {
+	Payments   []Payment `json:"payments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type GroupBy string
+
+const (
+	GroupByCategory GroupBy = "category"
+	GroupByAccount  GroupBy = "account"
+	GroupByStatus   GroupBy = "status"
+)
+
+// AggregateGroup holds statistics of payments with the same key. Average is truncated
+// to minor units, percentiles are amounts by nearest rank.
+type AggregateGroup struct {
+	Key     string `json:"key"`
+	Count   int    `json:"count"`
+	Total   Money  `json:"total"`
+	Average Money  `json:"average"`
+	Min     Money  `json:"min"`
+	Max     Money  `json:"max"`
+	P50     Money  `json:"p50"`
+	P90     Money  `json:"p90"`
+	P99     Money  `json:"p99"`
+}
+
+type AggregateReport struct {
+	GroupBy GroupBy          `json:"group_by"`
+	Groups  []AggregateGroup `json:"groups"`
+	Count   int              `json:"count"`
+	Total   Money            `json:"total"`
+}
+
+// TopEntry is place in leaderboard of accounts or categories, Key is id of account or category.
+type TopEntry struct {
+	Key   string `json:"key"`
+	Total Money  `json:"total"`
+	Count int    `json:"count"`
+}
+
+type Period string
+
+const (
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// SeriesPoint is spending in bucket which starts at Start, unix time in UTC.
+type SeriesPoint struct {
+	Start int64 `json:"start"`
+	Total Money `json:"total"`
+	Count int   `json:"count"`
+}
+
+type Series struct {
+	Key    string        `json:"key"`
+	Points []SeriesPoint `json:"points"`
+}
+
+// SpendingReport has series with the same buckets from From to To, To is exclusive.
+// GroupBy is empty for single series of all payments.
+type SpendingReport struct {
+	Period  Period   `json:"period"`
+	GroupBy GroupBy  `json:"group_by,omitempty"`
+	From    int64    `json:"from"`
+	To      int64    `json:"to"`
+	Series  []Series `json:"series"`
+}
+
+type TransferStatus string
+
+const (
+	TransferStatusCompleted TransferStatus = "COMPLETED"
+	TransferStatusPending   TransferStatus = "PENDING"
+	TransferStatusRefunded  TransferStatus = "REFUNDED"
+)
+
+// Transfer moves money from account to phone. Transfer to unregistered phone is pending claim:
+// ToAccountID is zero until account for phone is registered or money is refunded after ExpiresAt.
+type Transfer struct {
+	ID            string         `json:"id"`
+	FromAccountID int64          `json:"from_account_id"`
+	ToAccountID   int64          `json:"to_account_id,omitempty"`
+	Phone         Phone          `json:"phone"`
+	Amount        Money          `json:"amount"`
+	Status        TransferStatus `json:"status"`
+	Time          int64          `json:"time"`
+	ExpiresAt     int64          `json:"expires_at,omitempty"`
+}
+
+// PhoneChange records that account moved from OldPhone to NewPhone at Time.
+type PhoneChange struct {
+	AccountID int64 `json:"account_id"`
+	OldPhone  Phone `json:"old_phone"`
+	NewPhone  Phone `json:"new_phone"`
+	Time      int64 `json:"time"`
+}
+
+// AccountMerge is tombstone of account merged into IntoAccountID: Amount is balance moved
+// to it, Payments and Favorites are counts of moved payments and favorites.
+type AccountMerge struct {
+	AccountID     int64 `json:"account_id"`
+	IntoAccountID int64 `json:"into_account_id"`
+	Phone         Phone `json:"phone"`
+	Amount        Money `json:"amount"`
+	Payments      int   `json:"payments"`
+	Favorites     int   `json:"favorites"`
+	Time          int64 `json:"time"`
+}
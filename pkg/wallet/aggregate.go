@@ -0,0 +1,144 @@
+package wallet
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// AggregatePayments groups all payments by category, account or status and computes statistics of groups.
+func (s *Service) AggregatePayments(by types.GroupBy, goroutines int) (types.AggregateReport, error) {
+	return s.AggregatePaymentsContext(context.Background(), by, nil, goroutines)
+}
+
+// AggregatePaymentsContext groups payments selected by filter, nil filter selects all payments.
+// Rejected payments have zero amount, they are skipped, so they don't lower averages and counts,
+// but grouping by status keeps them in group FAIL. Amounts of groups are collected in parallel and groups are sorted by key, accounts by number.
+func (s *Service) AggregatePaymentsContext(ctx context.Context, by types.GroupBy, filter Predicate, goroutines int) (types.AggregateReport, error) {
+	key, err := groupKey(by)
+	if err != nil {
+		return types.AggregateReport{}, err
+	}
+
+	keepFailed := by == types.GroupByStatus
+	collected, err := s.ScanPayments(ctx, goroutines, func(payments []types.Payment) interface{} {
+		amounts := map[string][]types.Money{}
+		for _, payment := range payments {
+			if (keepFailed || payment.Status != types.PaymentStatusFail) && (filter == nil || filter(payment)) {
+				amounts[key(payment)] = append(amounts[key(payment)], payment.Amount)
+			}
+		}
+		return amounts
+	}, func(accumulated interface{}, partial interface{}) interface{} {
+		amounts := accumulated.(map[string][]types.Money)
+		for key, items := range partial.(map[string][]types.Money) {
+			amounts[key] = append(amounts[key], items...)
+		}
+		return amounts
+	}, map[string][]types.Money{})
+	if err != nil {
+		return types.AggregateReport{}, err
+	}
+
+	report := types.AggregateReport{GroupBy: by, Groups: []types.AggregateGroup{}}
+	for key, amounts := range collected.(map[string][]types.Money) {
+		group, err := aggregate(key, amounts)
+		if err != nil {
+			return types.AggregateReport{}, err
+		}
+
+		report.Groups = append(report.Groups, group)
+		report.Count += group.Count
+		report.Total, err = report.Total.Add(group.Total)
+		if err != nil {
+			return types.AggregateReport{}, err
+		}
+	}
+
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if by == types.GroupByAccount {
+			left, _ := strconv.ParseInt(report.Groups[i].Key, 10, 64)
+			right, _ := strconv.ParseInt(report.Groups[j].Key, 10, 64)
+			return left < right
+		}
+		return report.Groups[i].Key < report.Groups[j].Key
+	})
+
+	return report, nil
+}
+
+func groupKey(by types.GroupBy) (func(payment types.Payment) string, error) {
+	switch by {
+	case types.GroupByCategory:
+		return func(payment types.Payment) string { return string(payment.Category) }, nil
+	case types.GroupByAccount:
+		return func(payment types.Payment) string { return strconv.FormatInt(payment.AccountID, 10) }, nil
+	case types.GroupByStatus:
+		return func(payment types.Payment) string { return string(payment.Status) }, nil
+	}
+
+	return nil, fmt.Errorf("%w: can't group by %q", ErrBadQuery, by)
+}
+
+// aggregate computes statistics of not empty amounts, it sorts amounts.
+func aggregate(key string, amounts []types.Money) (types.AggregateGroup, error) {
+	sort.Slice(amounts, func(i, j int) bool { return amounts[i] < amounts[j] })
+
+	group := types.AggregateGroup{
+		Key:   key,
+		Count: len(amounts),
+		Min:   amounts[0],
+		Max:   amounts[len(amounts)-1],
+		P50:   percentile(amounts, 50),
+		P90:   percentile(amounts, 90),
+		P99:   percentile(amounts, 99),
+	}
+	for _, amount := range amounts {
+		var err error
+		group.Total, err = group.Total.Add(amount)
+		if err != nil {
+			return types.AggregateGroup{}, fmt.Errorf("group %s: %w", key, err)
+		}
+	}
+	group.Average = group.Total / types.Money(group.Count)
+
+	return group, nil
+}
+
+// percentile returns amount by nearest rank from sorted amounts.
+func percentile(sorted []types.Money, p int) types.Money {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// WriteAggregateCSV writes groups of report, one line per group.
+func WriteAggregateCSV(w io.Writer, report types.AggregateReport) error {
+	writer := csv.NewWriter(w)
+
+	records := [][]string{
+		{string(report.GroupBy), "count", "total", "average", "min", "max", "p50", "p90", "p99"},
+	}
+	for _, group := range report.Groups {
+		record := []string{group.Key, strconv.Itoa(group.Count)}
+		for _, amount := range []types.Money{group.Total, group.Average, group.Min, group.Max, group.P50, group.P90, group.P99} {
+			record = append(record, strconv.FormatInt(int64(amount), 10))
+		}
+		records = append(records, record)
+	}
+
+	err := writer.WriteAll(records)
+	if err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
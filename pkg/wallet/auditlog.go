@@ -0,0 +1,292 @@
+package wallet
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+var ErrAuditLogBroken = errors.New("audit log chain is broken")
+
+// DefaultActor is written to audit log when actor isn't set.
+const DefaultActor = "system"
+
+// AuditLog is append only file of audit records, one JSON record per line.
+// Every record keeps hash of previous one, so removed or changed record
+// breaks the chain and is found by VerifyAuditLog. Seq and hash of the last
+// record are kept in head file next to log, so records removed from the end are found too.
+type AuditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	headPath string
+	seq      int64
+	lastHash string
+}
+
+// AuditHeadPath returns path of head file of audit log.
+func AuditHeadPath(path string) string {
+	return path + ".head"
+}
+
+// OpenAuditLog opens audit log for appending, creating file if needed.
+// Existing records are verified before new ones are added.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	last, err := verifyAuditLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog := &AuditLog{file: file, headPath: AuditHeadPath(path)}
+	if last != nil {
+		auditLog.seq = last.Seq
+		auditLog.lastHash = last.Hash
+
+		// head is behind log when process stopped between writes, or log is older than head files
+		err = writeAuditHead(auditLog.headPath, last)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return auditLog, nil
+}
+
+func (l *AuditLog) Append(actor string, operation string, params map[string]string, result string, now time.Time) (*types.AuditRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record := &types.AuditRecord{
+		Seq:       l.seq + 1,
+		Time:      now.Unix(),
+		Actor:     actor,
+		Operation: operation,
+		Params:    params,
+		Result:    result,
+		PrevHash:  l.lastHash,
+	}
+
+	hash, err := auditHash(record)
+	if err != nil {
+		return nil, err
+	}
+	record.Hash = hash
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = l.file.Write(append(data, '\n'))
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.file.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	l.seq = record.Seq
+	l.lastHash = record.Hash
+
+	err = writeAuditHead(l.headPath, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Head returns hash of last record. It is also kept in head file, copy of it kept
+// out of reach of whoever can write the log allows to find replaced head file.
+func (l *AuditLog) Head() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastHash
+}
+
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+func auditHash(record *types.AuditRecord) (string, error) {
+	copied := *record
+	copied.Hash = ""
+
+	data, err := json.Marshal(copied)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeAuditHead replaces head file with seq and hash of record: Seq;Hash.
+func writeAuditHead(path string, record *types.AuditRecord) error {
+	err := actionByFile(path+".tmp", strconv.FormatInt(record.Seq, 10)+";"+record.Hash+"\n")
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// readAuditHead reads seq and hash of head file, ok is false when there is no head file.
+func readAuditHead(path string) (seq int64, hash string, ok bool, err error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+
+	fields := strings.Split(strings.TrimSpace(string(data)), ";")
+	if len(fields) != 2 {
+		return 0, "", false, fmt.Errorf("%w: head %q", ErrAuditLogBroken, data)
+	}
+
+	seq, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", false, fmt.Errorf("%w: head %q", ErrAuditLogBroken, data)
+	}
+
+	return seq, fields[1], true, nil
+}
+
+// VerifyAuditLog checks every record of audit log and its head file and returns hash of the last record.
+func VerifyAuditLog(path string) (string, error) {
+	last, err := verifyAuditLog(path)
+	if err != nil {
+		return "", err
+	}
+
+	if last == nil {
+		return "", nil
+	}
+
+	return last.Hash, nil
+}
+
+func verifyAuditLog(path string) (*types.AuditRecord, error) {
+	headSeq, headHash, headOK, err := readAuditHead(AuditHeadPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) && headOK {
+		return nil, fmt.Errorf("%w: log with %d records was removed", ErrAuditLogBroken, headSeq)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	var last *types.AuditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		record := &types.AuditRecord{}
+		err = json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrAuditLogBroken, line, err)
+		}
+
+		hash, err := auditHash(record)
+		if err != nil {
+			return nil, err
+		}
+
+		if hash != record.Hash {
+			return nil, fmt.Errorf("%w: line %d: record was changed", ErrAuditLogBroken, line)
+		}
+
+		prevHash := ""
+		var prevSeq int64
+		if last != nil {
+			prevHash = last.Hash
+			prevSeq = last.Seq
+		}
+
+		if record.PrevHash != prevHash || record.Seq != prevSeq+1 {
+			return nil, fmt.Errorf("%w: line %d: previous record is missing", ErrAuditLogBroken, line)
+		}
+
+		if headOK && record.Seq == headSeq && record.Hash != headHash {
+			return nil, fmt.Errorf("%w: line %d: record doesn't match head", ErrAuditLogBroken, line)
+		}
+
+		last = record
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// records written after head are allowed: process could stop before head was replaced
+	if headOK && (last == nil || last.Seq < headSeq) {
+		return nil, fmt.Errorf("%w: log ends before record %d of head, records were removed", ErrAuditLogBroken, headSeq)
+	}
+
+	return last, nil
+}
+
+// SetAuditLog makes service write record for every money operation and import.
+func (s *Service) SetAuditLog(auditLog *AuditLog) {
+	s.auditLog = auditLog
+}
+
+// SetActor sets who is making next operations, it is written to audit log.
+func (s *Service) SetActor(actor string) {
+	s.actor = actor
+}
+
+func (s *Service) audit(operation string, params map[string]string, result string, err error) {
+	if s.auditLog == nil {
+		return
+	}
+
+	if err != nil {
+		result = "error: " + err.Error()
+	}
+
+	actor := s.actor
+	if actor == "" {
+		actor = DefaultActor
+	}
+
+	_, err = s.auditLog.Append(actor, operation, params, result, s.now())
+	if err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,160 @@
+package wallet
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// BackpressurePolicy tells what to do with event when buffer of subscriber is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock waits until subscriber reads event (or unsubscribes).
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropNewest drops event which doesn't fit into buffer.
+	BackpressureDropNewest
+	// BackpressureDropOldest drops the oldest buffered event to make room for new one.
+	BackpressureDropOldest
+)
+
+type Subscription struct {
+	C <-chan types.Event
+
+	ch      chan types.Event
+	done    chan struct{}
+	once    sync.Once
+	policy  BackpressurePolicy
+	filter  map[types.EventType]bool
+	bus     *eventBus
+	dropped int64
+}
+
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]bool
+}
+
+// Subscribe returns subscription to events of given types (all events when no types given).
+// Channel of subscription is closed after Unsubscribe.
+func (s *Service) Subscribe(buffer int, policy BackpressurePolicy, eventTypes ...types.EventType) *Subscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	ch := make(chan types.Event, buffer)
+	subscription := &Subscription{
+		C:      ch,
+		ch:     ch,
+		done:   make(chan struct{}),
+		policy: policy,
+		bus:    &s.events,
+	}
+
+	if len(eventTypes) != 0 {
+		subscription.filter = make(map[types.EventType]bool)
+		for _, eventType := range eventTypes {
+			subscription.filter[eventType] = true
+		}
+	}
+
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+
+	if s.events.subscribers == nil {
+		s.events.subscribers = make(map[*Subscription]bool)
+	}
+	s.events.subscribers[subscription] = true
+
+	return subscription
+}
+
+// Unsubscribe stops delivery and closes channel of subscription. It is safe to call it more than once.
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(func() {
+		close(sub.done)
+
+		sub.bus.mu.Lock()
+		defer sub.bus.mu.Unlock()
+
+		delete(sub.bus.subscribers, sub)
+		close(sub.ch)
+	})
+}
+
+// Dropped returns number of events which were dropped because of full buffer.
+func (sub *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&sub.dropped)
+}
+
+func (sub *Subscription) deliver(event types.Event) {
+	if sub.filter != nil && !sub.filter[event.Type] {
+		return
+	}
+
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case BackpressureDropNewest:
+		select {
+		case sub.ch <- event:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+		}
+	case BackpressureDropOldest:
+		if cap(sub.ch) == 0 {
+			select {
+			case sub.ch <- event:
+			default:
+				atomic.AddInt64(&sub.dropped, 1)
+			}
+			return
+		}
+
+		for {
+			select {
+			case sub.ch <- event:
+				return
+			default:
+			}
+
+			select {
+			case <-sub.ch:
+				atomic.AddInt64(&sub.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case sub.ch <- event:
+		case <-sub.done:
+		}
+	}
+}
+
+func (s *Service) publish(event types.Event) {
+	event.Time = s.now().Unix()
+
+	s.events.mu.RLock()
+	defer s.events.mu.RUnlock()
+
+	for subscription := range s.events.subscribers {
+		subscription.deliver(event)
+	}
+}
+
+func (s *Service) publishPayment(eventType types.EventType, payment *types.Payment, originalPaymentID string) {
+	copied := *payment
+	s.publish(types.Event{
+		Type:              eventType,
+		AccountID:         payment.AccountID,
+		Amount:            payment.Amount,
+		Payment:           &copied,
+		OriginalPaymentID: originalPaymentID,
+	})
+}
@@ -0,0 +1,476 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+// ImportSource is source of opening transactions which are posted on import
+// for balances that came without journal history.
+const ImportSource = "import"
+
+const walletLedgerPrefix = "wallet:"
+
+const claimsLedgerPrefix = "claims:"
+
+// System ledger accounts. Every movement of money is posted as transaction
+// between wallet of customer and one of them, so sum of all balances is always zero.
+const (
+	RefundsLedger types.LedgerAccount = "system:refunds"
+	OpeningLedger types.LedgerAccount = "system:opening"
+)
+
+func WalletLedger(accountID int64) types.LedgerAccount {
+	return types.LedgerAccount(walletLedgerPrefix + strconv.FormatInt(accountID, 10))
+}
+
+// ClaimsLedger holds money sent to phone which isn't registered yet.
+func ClaimsLedger(phone types.Phone) types.LedgerAccount {
+	return types.LedgerAccount(claimsLedgerPrefix + string(phone))
+}
+
+// ErrReservedCharacter is returned for category or source of money which can't be written into
+// dumps: columns are separated by ';', postings of journal by ',' and amounts by '='.
+var ErrReservedCharacter = errors.New("value contains reserved character")
+
+// checkLedgerName checks that name of ledger account part can be written into dumps as is.
+func checkLedgerName(kind string, name string) error {
+	if strings.ContainsAny(name, ";,=\r\n") {
+		return fmt.Errorf("%w: %s %q can't contain ';', ',', '=' or line breaks", ErrReservedCharacter, kind, name)
+	}
+
+	return nil
+}
+
+func DepositsLedger(source string) types.LedgerAccount {
+	return types.LedgerAccount("deposits:" + source)
+}
+
+func CategoryLedger(category types.PaymentCategory) types.LedgerAccount {
+	return types.LedgerAccount("category:" + string(category))
+}
+
+func walletID(account types.LedgerAccount) (int64, bool) {
+	if !strings.HasPrefix(string(account), walletLedgerPrefix) {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(string(account), walletLedgerPrefix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+func checkPostings(postings []types.Posting) error {
+	var sum types.Money
+	for _, posting := range postings {
+		var err error
+		sum, err = sum.Add(posting.Amount)
+		if err != nil {
+			return fmt.Errorf("sum of postings: %w", err)
+		}
+	}
+
+	if len(postings) < 2 || sum != 0 {
+		return ErrUnbalancedTransaction
+	}
+
+	return nil
+}
+
+// post appends balanced transaction to journal and updates balances of wallets touched by it.
+func (s *Service) post(transactionType types.EntryType, paymentID string, source string, postings ...types.Posting) (*types.Transaction, error) {
+	err := checkPostings(postings)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := &types.Transaction{
+		ID:        uuid.New().String(),
+		Type:      transactionType,
+		PaymentID: paymentID,
+		Source:    source,
+		Time:      s.now().Unix(),
+		Postings:  postings,
+	}
+
+	err = s.appendTransaction(transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, posting := range postings {
+		s.syncBalance(posting.Account)
+	}
+
+	return transaction, nil
+}
+
+// appendTransaction appends transaction to journal and updates balances of its ledger accounts.
+// Transaction which overflows balance isn't appended, error is *types.OverflowError.
+func (s *Service) appendTransaction(transaction *types.Transaction) error {
+	if s.balances == nil {
+		s.balances = make(map[types.LedgerAccount]types.Money)
+	}
+
+	balances := make(map[types.LedgerAccount]types.Money, len(transaction.Postings))
+	for _, posting := range transaction.Postings {
+		balance, ok := balances[posting.Account]
+		if !ok {
+			balance = s.balances[posting.Account]
+		}
+
+		balance, err := balance.Add(posting.Amount)
+		if err != nil {
+			return fmt.Errorf("ledger account %s: %w", posting.Account, err)
+		}
+		balances[posting.Account] = balance
+	}
+
+	for account, balance := range balances {
+		s.balances[account] = balance
+	}
+	s.journal = append(s.journal, transaction)
+	return nil
+}
+
+// syncBalance refreshes Balance of account from journal, Balance is only a view of it.
+func (s *Service) syncBalance(ledger types.LedgerAccount) {
+	id, ok := walletID(ledger)
+	if !ok {
+		return
+	}
+
+	account, err := s.FindAccountByID(id)
+	if err != nil {
+		return
+	}
+
+	account.Balance = s.balances[ledger]
+}
+
+func (s *Service) FindTransactionByID(id string) (*types.Transaction, error) {
+	for _, transaction := range s.journal {
+		if transaction.ID == id {
+			return transaction, nil
+		}
+	}
+
+	return nil, ErrTransactionNotFound
+}
+
+func (s *Service) walletEntries(accountID int64) []types.Entry {
+	ledger := WalletLedger(accountID)
+
+	entries := []types.Entry{}
+	for _, transaction := range s.journal {
+		for _, posting := range transaction.Postings {
+			if posting.Account != ledger {
+				continue
+			}
+
+			entries = append(entries, types.Entry{
+				ID:        transaction.ID,
+				AccountID: accountID,
+				Type:      transaction.Type,
+				Amount:    posting.Amount,
+				PaymentID: transaction.PaymentID,
+				Source:    transaction.Source,
+				Time:      transaction.Time,
+			})
+		}
+	}
+
+	return entries
+}
+
+// AccountEntries returns movements of account in order they were posted.
+func (s *Service) AccountEntries(accountID int64) ([]types.Entry, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.walletEntries(accountID), nil
+}
+
+// CheckLedger checks that every transaction is balanced and
+// balance of every account equals balance of its wallet in journal.
+func (s *Service) CheckLedger() error {
+	balances := make(map[types.LedgerAccount]types.Money)
+	for _, transaction := range s.journal {
+		err := checkPostings(transaction.Postings)
+		if err != nil {
+			return fmt.Errorf("%w: transaction %s", err, transaction.ID)
+		}
+
+		for _, posting := range transaction.Postings {
+			balances[posting.Account], err = balances[posting.Account].Add(posting.Amount)
+			if err != nil {
+				return fmt.Errorf("transaction %s: %w", transaction.ID, err)
+			}
+		}
+	}
+
+	for _, account := range s.accounts {
+		balance := balances[WalletLedger(account.ID)]
+		if balance != account.Balance {
+			return fmt.Errorf("%w: account %d balance %d, ledger %d", ErrBalanceMismatch, account.ID, account.Balance, balance)
+		}
+	}
+
+	return nil
+}
+
+// TrialBalance lists balances of all ledger accounts, positive balances are debits
+// and negative are credits. Debit and credit totals are equal when books balance.
+// Totals which overflow Money give *types.OverflowError.
+func (s *Service) TrialBalance() (types.TrialBalance, error) {
+	trialBalance := types.TrialBalance{}
+	for account, balance := range s.balances {
+		line := types.TrialBalanceLine{Account: account}
+		var err error
+		if balance >= 0 {
+			line.Debit = balance
+		} else {
+			line.Credit, err = balance.Mul(-1)
+			if err != nil {
+				return types.TrialBalance{}, fmt.Errorf("ledger account %s: %w", account, err)
+			}
+		}
+
+		trialBalance.Debit, err = trialBalance.Debit.Add(line.Debit)
+		if err != nil {
+			return types.TrialBalance{}, fmt.Errorf("debit: %w", err)
+		}
+		trialBalance.Credit, err = trialBalance.Credit.Add(line.Credit)
+		if err != nil {
+			return types.TrialBalance{}, fmt.Errorf("credit: %w", err)
+		}
+		trialBalance.Lines = append(trialBalance.Lines, line)
+	}
+
+	sort.Slice(trialBalance.Lines, func(i, j int) bool {
+		return trialBalance.Lines[i].Account < trialBalance.Lines[j].Account
+	})
+
+	return trialBalance, nil
+}
+
+func WriteTrialBalance(w io.Writer, trialBalance types.TrialBalance) error {
+	_, err := fmt.Fprintf(w, "%-32s  %12s  %12s\n", "Account", "Debit", "Credit")
+	if err != nil {
+		return err
+	}
+
+	for _, line := range trialBalance.Lines {
+		_, err = fmt.Fprintf(w, "%-32s  %12d  %12d\n", line.Account, line.Debit, line.Credit)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%-32s  %12d  %12d\n", "TOTAL", trialBalance.Debit, trialBalance.Credit)
+	return err
+}
+
+// addOpeningEntries posts difference between imported balances and journal
+// against opening ledger and refreshes all balances from journal.
+func (s *Service) addOpeningEntries() error {
+	for _, account := range s.accounts {
+		ledger := WalletLedger(account.ID)
+		diff, err := account.Balance.Sub(s.balances[ledger])
+		if err != nil {
+			return fmt.Errorf("account %d: %w", account.ID, err)
+		}
+
+		if diff != 0 {
+			negated, err := diff.Mul(-1)
+			if err != nil {
+				return fmt.Errorf("account %d: %w", account.ID, err)
+			}
+
+			_, err = s.post(types.EntryTypeOpening, "", ImportSource,
+				types.Posting{Account: ledger, Amount: diff},
+				types.Posting{Account: OpeningLedger, Amount: negated},
+			)
+			if err != nil {
+				return fmt.Errorf("account %d: %w", account.ID, err)
+			}
+		}
+
+		s.syncBalance(ledger)
+	}
+
+	return nil
+}
+
+// transactionIDs indexes ids of journal, import checks dump lines by it instead of FindTransactionByID.
+func (s *Service) transactionIDs() map[string]bool {
+	ids := make(map[string]bool, len(s.journal))
+	for _, transaction := range s.journal {
+		ids[transaction.ID] = true
+	}
+
+	return ids
+}
+
+func (s *Service) actionByJournal(path string, known map[string]bool, options importOptions) error {
+	byteData, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(ErrFileNotFound.Error())
+		return nil
+	}
+
+	for _, split := range strings.Split(string(byteData), "\n") {
+		if len(split) == 0 {
+			break
+		}
+
+		data := strings.Split(split, ";")
+		if len(data) != 6 {
+			log.Println("wrong transaction format")
+			return ErrWrongFormat
+		}
+
+		unix, err := strconv.ParseInt(data[4], 10, 64)
+		if err != nil {
+			log.Println("can't parse str to int")
+			return err
+		}
+
+		postings := []types.Posting{}
+		for _, column := range strings.Split(data[5], ",") {
+			index := strings.LastIndex(column, "=")
+			if index < 0 {
+				log.Println("wrong posting format")
+				return ErrWrongFormat
+			}
+
+			amount, err := strconv.Atoi(column[index+1:])
+			if err != nil {
+				log.Println("can't parse str to int")
+				return err
+			}
+
+			postings = append(postings, types.Posting{
+				Account: types.LedgerAccount(column[:index]),
+				Amount:  types.Money(amount),
+			})
+		}
+
+		err = checkPostings(postings)
+		if err != nil {
+			return fmt.Errorf("%w: transaction %s", err, data[0])
+		}
+
+		// raw import keeps duplicates of journal, entries are still skipped when journal has them
+		if known[data[0]] && !options.raw {
+			continue
+		}
+
+		err = s.appendTransaction(&types.Transaction{
+			ID:        data[0],
+			Type:      types.EntryType(data[1]),
+			PaymentID: data[2],
+			Source:    data[3],
+			Time:      unix,
+			Postings:  postings,
+		})
+		if err != nil {
+			return fmt.Errorf("transaction %s: %w", data[0], err)
+		}
+		known[data[0]] = true
+	}
+
+	return nil
+}
+
+// actionByEntries reads single entry ledger of older dumps and posts it to journal.
+func (s *Service) actionByEntries(path string, known map[string]bool) error {
+	byteData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	for _, split := range strings.Split(string(byteData), "\n") {
+		if len(split) == 0 {
+			break
+		}
+
+		data := strings.Split(split, ";")
+		if len(data) != 7 {
+			log.Println("wrong entry format")
+			return ErrWrongFormat
+		}
+
+		accountID, err := strconv.Atoi(data[1])
+		if err != nil {
+			log.Println("can't parse str to int")
+			return err
+		}
+
+		amount, err := strconv.Atoi(data[3])
+		if err != nil {
+			log.Println("can't parse str to int")
+			return err
+		}
+
+		unix, err := strconv.ParseInt(data[6], 10, 64)
+		if err != nil {
+			log.Println("can't parse str to int")
+			return err
+		}
+
+		if known[data[0]] {
+			continue
+		}
+
+		entryType := types.EntryType(data[2])
+		source := data[5]
+
+		contra := OpeningLedger
+		switch entryType {
+		case types.EntryTypeDeposit:
+			contra = DepositsLedger(source)
+		case types.EntryTypePayment:
+			contra = CategoryLedger(types.PaymentCategory(source))
+		case types.EntryTypeRefund:
+			contra = RefundsLedger
+		}
+
+		negated, err := types.Money(amount).Mul(-1)
+		if err != nil {
+			return fmt.Errorf("entry %s: %w", data[0], err)
+		}
+
+		err = s.appendTransaction(&types.Transaction{
+			ID:        data[0],
+			Type:      entryType,
+			PaymentID: data[4],
+			Source:    source,
+			Time:      unix,
+			Postings: []types.Posting{
+				{Account: WalletLedger(int64(accountID)), Amount: types.Money(amount)},
+				{Account: contra, Amount: negated},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("entry %s: %w", data[0], err)
+		}
+		known[data[0]] = true
+	}
+
+	return nil
+}
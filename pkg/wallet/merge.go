@@ -0,0 +1,234 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+var ErrMergeToSelf = errors.New("can't merge account into itself")
+
+// MergeSource is source of transactions which move balance of merged account.
+const MergeSource = "merge"
+
+// AccountMergedError is returned for id of merged account, errors.Is(err, ErrAccountNotFound) is true for it.
+// IntoAccountID is account which has payments of merged account now.
+type AccountMergedError struct {
+	AccountID     int64
+	IntoAccountID int64
+	Time          int64
+}
+
+func (e *AccountMergedError) Error() string {
+	return fmt.Sprintf("%v: account %d was merged into account %d at %s",
+		ErrAccountNotFound, e.AccountID, e.IntoAccountID, time.Unix(e.Time, 0).UTC().Format(time.RFC3339))
+}
+
+func (e *AccountMergedError) Unwrap() error {
+	return ErrAccountNotFound
+}
+
+// findMerge returns tombstone of merged account or nil.
+func (s *Service) findMerge(accountID int64) *types.AccountMerge {
+	for _, merge := range s.merges {
+		if merge.AccountID == accountID {
+			return merge
+		}
+	}
+
+	return nil
+}
+
+// accountMerged returns *AccountMergedError for merged account or ErrAccountNotFound.
+// Account merged into account which was merged later points to the last one.
+func (s *Service) accountMerged(accountID int64) error {
+	merge := s.findMerge(accountID)
+	if merge == nil {
+		return ErrAccountNotFound
+	}
+
+	into := merge.IntoAccountID
+	for i := 0; i < len(s.merges); i++ {
+		next := s.findMerge(into)
+		if next == nil {
+			break
+		}
+		into = next.IntoAccountID
+	}
+
+	return &AccountMergedError{AccountID: accountID, IntoAccountID: into, Time: merge.Time}
+}
+
+// MergeAccounts moves balance, payments, favorites and pending transfers of duplicate account
+// mergeID to account keepID and removes duplicate. Its id stays as tombstone, so FindAccountByID
+// returns *AccountMergedError for it, and its phone can be registered again. Journal keeps
+// postings of merged account, balance is moved by transfer transaction with source MergeSource.
+// Both accounts must be active.
+func (s *Service) MergeAccounts(keepID int64, mergeID int64) (merge *types.AccountMerge, err error) {
+	defer func() {
+		result := ""
+		if merge != nil {
+			result = strconv.FormatInt(int64(merge.Amount), 10)
+		}
+		s.audit("merge-accounts", map[string]string{
+			"keep":  strconv.FormatInt(keepID, 10),
+			"merge": strconv.FormatInt(mergeID, 10),
+		}, result, err)
+	}()
+
+	if keepID == mergeID {
+		return nil, ErrMergeToSelf
+	}
+
+	keep, err := s.FindAccountByID(keepID)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := s.FindAccountByID(mergeID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActive(keep)
+	if err != nil {
+		return nil, err
+	}
+
+	// balance of frozen account must not leave it by merge
+	err = checkActive(merged)
+	if err != nil {
+		return nil, fmt.Errorf("account %d: %w", merged.ID, err)
+	}
+
+	merge = &types.AccountMerge{
+		AccountID:     merged.ID,
+		IntoAccountID: keep.ID,
+		Phone:         merged.Phone,
+		Amount:        s.balances[WalletLedger(merged.ID)],
+		Time:          s.now().Unix(),
+	}
+
+	if merge.Amount != 0 {
+		_, err = s.post(types.EntryTypeTransfer, "", MergeSource,
+			types.Posting{Account: WalletLedger(merged.ID), Amount: -merge.Amount},
+			types.Posting{Account: WalletLedger(keep.ID), Amount: merge.Amount},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, payment := range s.payments {
+		if payment.AccountID == merged.ID {
+			payment.AccountID = keep.ID
+			merge.Payments++
+		}
+	}
+
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == merged.ID {
+			favorite.AccountID = keep.ID
+			merge.Favorites++
+		}
+	}
+
+	for _, transfer := range s.transfers {
+		if transfer.Status == types.TransferStatusPending && transfer.FromAccountID == merged.ID {
+			transfer.FromAccountID = keep.ID
+		}
+	}
+
+	for i, account := range s.accounts {
+		if account == merged {
+			s.accounts = append(s.accounts[:i], s.accounts[i+1:]...)
+			break
+		}
+	}
+	// duplicate of merged account may have the same phone, so index is built again
+	s.phones = nil
+
+	s.merges = append(s.merges, merge)
+	s.publish(types.Event{Type: types.EventAccountMerged, AccountID: keep.ID, Amount: merge.Amount})
+
+	return merge, nil
+}
+
+// Merges returns copies of tombstones of merged accounts.
+func (s *Service) Merges() []types.AccountMerge {
+	merges := make([]types.AccountMerge, 0, len(s.merges))
+	for _, merge := range s.merges {
+		merges = append(merges, *merge)
+	}
+
+	return merges
+}
+
+// exportMerges formats merges.dump: AccountID;IntoAccountID;Phone;Amount;Payments;Favorites;Time.
+func (s *Service) exportMerges() string {
+	result := ""
+	for _, merge := range s.merges {
+		result += strconv.FormatInt(merge.AccountID, 10) + ";"
+		result += strconv.FormatInt(merge.IntoAccountID, 10) + ";"
+		result += string(merge.Phone) + ";"
+		result += strconv.FormatInt(int64(merge.Amount), 10) + ";"
+		result += strconv.Itoa(merge.Payments) + ";"
+		result += strconv.Itoa(merge.Favorites) + ";"
+		result += strconv.FormatInt(merge.Time, 10) + "\n"
+	}
+
+	return result
+}
+
+// actionByMerges replaces tombstones of merged accounts by merges.dump.
+func (s *Service) actionByMerges(path string) error {
+	byteData, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(ErrFileNotFound.Error())
+		return nil
+	}
+
+	merges := []*types.AccountMerge{}
+	for _, line := range strings.Split(string(byteData), "\n") {
+		if line == "" {
+			continue
+		}
+
+		data := strings.Split(line, ";")
+		if len(data) != 7 {
+			return fmt.Errorf("%w: merge %q", ErrWrongFormat, line)
+		}
+
+		numbers := make([]int64, 0, 6)
+		for _, index := range []int{0, 1, 3, 4, 5, 6} {
+			number, err := strconv.ParseInt(data[index], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: merge %q", ErrWrongFormat, line)
+			}
+			numbers = append(numbers, number)
+		}
+
+		if s.nextAccountID < numbers[0] {
+			s.nextAccountID = numbers[0]
+		}
+
+		merges = append(merges, &types.AccountMerge{
+			AccountID:     numbers[0],
+			IntoAccountID: numbers[1],
+			Phone:         types.Phone(data[2]),
+			Amount:        types.Money(numbers[2]),
+			Payments:      int(numbers[3]),
+			Favorites:     int(numbers[4]),
+			Time:          numbers[5],
+		})
+	}
+
+	s.merges = merges
+	return nil
+}
@@ -0,0 +1,274 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+var ErrUnknownCurrency = errors.New("unknown currency")
+var ErrUnknownLocale = errors.New("unknown locale")
+
+// AmountError describes why amount can't be parsed, errors.Is(err, ErrInvalidAmount) is true for it.
+type AmountError struct {
+	Text   string
+	Reason string
+}
+
+func (e *AmountError) Error() string {
+	return fmt.Sprintf("%v %q: %s", ErrInvalidAmount, e.Text, e.Reason)
+}
+
+func (e *AmountError) Unwrap() error {
+	return ErrInvalidAmount
+}
+
+// Currency is code of ISO 4217 and exponent of its minor unit: 123450 of currency
+// with exponent 2 is 1234.50, exponent 0 means currency without minor units.
+type Currency struct {
+	Code     string
+	Exponent int
+}
+
+var currencies = map[string]Currency{
+	"TJS": {Code: "TJS", Exponent: 2},
+	"USD": {Code: "USD", Exponent: 2},
+	"EUR": {Code: "EUR", Exponent: 2},
+	"RUB": {Code: "RUB", Exponent: 2},
+	"KZT": {Code: "KZT", Exponent: 2},
+	"UZS": {Code: "UZS", Exponent: 2},
+	"JPY": {Code: "JPY", Exponent: 0},
+	"KWD": {Code: "KWD", Exponent: 3},
+}
+
+// DefaultCurrency is currency of all amounts of wallet.
+const DefaultCurrency = "TJS"
+
+func FindCurrency(code string) (Currency, error) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+
+	return currency, nil
+}
+
+// Locale is separator of groups of thousands and decimal separator of amounts.
+type Locale struct {
+	Group   string
+	Decimal string
+}
+
+// DefaultLocale writes amounts like 1 234.50 TJS.
+var DefaultLocale = Locale{Group: " ", Decimal: "."}
+
+var locales = map[string]Locale{
+	"":   DefaultLocale,
+	"en": {Group: ",", Decimal: "."},
+	"ru": {Group: " ", Decimal: ","},
+	"tg": {Group: " ", Decimal: ","},
+}
+
+// FindLocale finds locale by language code, empty name is DefaultLocale.
+func FindLocale(name string) (Locale, error) {
+	locale, ok := locales[strings.ToLower(name)]
+	if !ok {
+		return Locale{}, fmt.Errorf("%w %q", ErrUnknownLocale, name)
+	}
+
+	return locale, nil
+}
+
+// Format writes amount in minor units like -1 234.50 TJS.
+func (c Currency) Format(amount types.Money, locale Locale) string {
+	// magnitude of math.MinInt64 doesn't fit into int64
+	magnitude := uint64(amount)
+	if amount < 0 {
+		magnitude = uint64(-(amount + 1)) + 1
+	}
+
+	digits := strconv.FormatUint(magnitude, 10)
+	if len(digits) <= c.Exponent {
+		digits = strings.Repeat("0", c.Exponent-len(digits)+1) + digits
+	}
+	integer, fraction := digits[:len(digits)-c.Exponent], digits[len(digits)-c.Exponent:]
+
+	result := strings.Builder{}
+	if amount < 0 {
+		result.WriteString("-")
+	}
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			result.WriteString(locale.Group)
+		}
+		result.WriteRune(digit)
+	}
+	if c.Exponent > 0 {
+		result.WriteString(locale.Decimal)
+		result.WriteString(fraction)
+	}
+	result.WriteString(" ")
+	result.WriteString(c.Code)
+
+	return result.String()
+}
+
+// Parse reads amount like 1 234.50 written without code of currency and returns it in minor units.
+// Separators of groups may be omitted, spaces are always allowed between groups. Groups after
+// the first one must have 3 digits, so 12,34 isn't read as 1234 in locale en.
+func (c Currency) Parse(text string, locale Locale) (types.Money, error) {
+	number := strings.TrimSpace(text)
+	negative := strings.HasPrefix(number, "-")
+	if negative || strings.HasPrefix(number, "+") {
+		number = number[1:]
+	}
+
+	integer, fraction := number, ""
+	index := strings.Index(number, locale.Decimal)
+	if index >= 0 {
+		integer, fraction = number[:index], number[index+len(locale.Decimal):]
+		if c.Exponent == 0 {
+			return 0, &AmountError{Text: text, Reason: fmt.Sprintf("%s has no minor units", c.Code)}
+		}
+		if len(fraction) == 0 || len(fraction) > c.Exponent {
+			return 0, &AmountError{Text: text, Reason: fmt.Sprintf("%s must have from 1 to %d digits after %q", c.Code, c.Exponent, locale.Decimal)}
+		}
+	}
+
+	integer, err := joinGroups(text, integer, locale)
+	if err != nil {
+		return 0, err
+	}
+
+	if integer == "" {
+		return 0, &AmountError{Text: text, Reason: "no digits before decimal separator"}
+	}
+
+	digits := integer + fraction + strings.Repeat("0", c.Exponent-len(fraction))
+	var amount types.Money
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, &AmountError{Text: text, Reason: fmt.Sprintf("unexpected character %q", r)}
+		}
+
+		var err error
+		amount, err = amount.Mul(10)
+		if err == nil {
+			amount, err = amount.Add(types.Money(r - '0'))
+		}
+		if err != nil {
+			return 0, fmt.Errorf("amount %q: %w", text, err)
+		}
+	}
+
+	if negative {
+		return -amount, nil
+	}
+
+	return amount, nil
+}
+
+// joinGroups removes separators of groups of thousands from integer part of amount text.
+func joinGroups(text string, integer string, locale Locale) (string, error) {
+	for _, separator := range []string{locale.Group, " ", "\u00a0"} {
+		if separator != "" && separator != locale.Decimal {
+			integer = strings.ReplaceAll(integer, separator, "\x00")
+		}
+	}
+
+	groups := strings.Split(integer, "\x00")
+	if len(groups) == 1 {
+		return integer, nil
+	}
+
+	for i, group := range groups {
+		if (i == 0 && (group == "" || len(group) > 3)) || (i > 0 && len(group) != 3) {
+			return "", &AmountError{Text: text, Reason: "groups of thousands must have 3 digits"}
+		}
+	}
+
+	return strings.Join(groups, ""), nil
+}
+
+// ParseMoney reads amount like 1 234.50 TJS and returns it in minor units of its currency,
+// amount without code of currency is in DefaultCurrency.
+func ParseMoney(text string, locale Locale) (types.Money, Currency, error) {
+	trimmed := strings.TrimSpace(text)
+	number := strings.TrimRightFunc(trimmed, func(r rune) bool {
+		return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')
+	})
+
+	code := trimmed[len(number):]
+	if code == "" {
+		code = DefaultCurrency
+	}
+
+	currency, err := FindCurrency(code)
+	if err != nil {
+		return 0, Currency{}, err
+	}
+
+	amount, err := currency.Parse(number, locale)
+	if err != nil {
+		return 0, Currency{}, err
+	}
+
+	return amount, currency, nil
+}
+
+// ParseAmount reads amount of wallet like 1 234.50 or 1 234 TJS and returns it in minor units.
+// Number without decimal separator and code of currency is rejected: 1250 could be 12.50 or 1 250.00.
+func ParseAmount(text string, locale Locale) (types.Money, error) {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return 0, &AmountError{Text: text, Reason: "no digits"}
+	}
+
+	last, _ := utf8.DecodeLastRuneInString(trimmed)
+	if !unicode.IsLetter(last) && !strings.Contains(trimmed, locale.Decimal) {
+		return 0, &AmountError{Text: text, Reason: fmt.Sprintf("amount must have decimal separator %q or currency like %s",
+			locale.Decimal, FormatMoney(123450, locale))}
+	}
+
+	amount, currency, err := ParseMoney(text, locale)
+	if err != nil {
+		return 0, err
+	}
+
+	if currency.Code != DefaultCurrency {
+		return 0, &AmountError{Text: text, Reason: "wallet keeps amounts in " + DefaultCurrency}
+	}
+
+	return amount, nil
+}
+
+// FormatMoney writes amount of wallet in DefaultCurrency like 1 234.50 TJS.
+func FormatMoney(amount types.Money, locale Locale) string {
+	return currencies[DefaultCurrency].Format(amount, locale)
+}
+
+// parseAmount reads amount column of dump: count of minor units, or amount
+// in DefaultCurrency written for people when dumps are imported by ImportHuman.
+func (o importOptions) parseAmount(text string) (types.Money, error) {
+	if o.locale == nil {
+		amount, err := strconv.ParseInt(text, 10, 64)
+		return types.Money(amount), err
+	}
+
+	amount, currency, err := ParseMoney(text, *o.locale)
+	if err != nil {
+		return 0, err
+	}
+
+	if currency.Code != DefaultCurrency {
+		return 0, &AmountError{Text: text, Reason: "wallet keeps amounts in " + DefaultCurrency}
+	}
+
+	return amount, nil
+}
@@ -0,0 +1,142 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// PhoneError describes why phone number is invalid, errors.Is(err, ErrInvalidPhone) is true for it.
+type PhoneError struct {
+	Phone  string
+	Reason string
+}
+
+func (e *PhoneError) Error() string {
+	return fmt.Sprintf("%v %q: %s", ErrInvalidPhone, e.Phone, e.Reason)
+}
+
+func (e *PhoneError) Unwrap() error {
+	return ErrInvalidPhone
+}
+
+// PhoneConflict is account of dump whose phone is already registered to other account.
+type PhoneConflict struct {
+	Phone          types.Phone
+	AccountID      int64
+	OtherAccountID int64
+}
+
+// PhoneConflictError is returned by Import when phones of accounts are the same after normalization,
+// errors.Is(err, ErrPhoneRegistered) is true for it. Dumps are loaded anyway, so duplicates can be merged by MergeAccounts.
+type PhoneConflictError struct {
+	Conflicts []PhoneConflict
+}
+
+func (e *PhoneConflictError) Error() string {
+	conflicts := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		conflicts = append(conflicts, fmt.Sprintf("phone %s of account %d is registered to account %d",
+			conflict.Phone, conflict.AccountID, conflict.OtherAccountID))
+	}
+
+	return fmt.Sprintf("%v: %s", ErrPhoneRegistered, strings.Join(conflicts, ", "))
+}
+
+func (e *PhoneConflictError) Unwrap() error {
+	return ErrPhoneRegistered
+}
+
+// phoneCountry is numbering plan of country: length of national number and prefixes of operators.
+type phoneCountry struct {
+	code     string
+	length   int
+	prefixes []string
+}
+
+// phoneCountries have own rules, numbers of other countries are checked only by length of E.164.
+var phoneCountries = []phoneCountry{
+	// Tajikistan
+	{code: "992", length: 9, prefixes: []string{"11", "12", "20", "50", "55", "77", "88", "90", "91", "92", "93", "98", "99"}},
+}
+
+// DefaultPhoneCountry is code of country for numbers written without it.
+const DefaultPhoneCountry = "992"
+
+// NormalizePhone converts phone to E.164 like +992988000011. Spaces, dashes, dots and parentheses
+// are removed, 00 before code of country is replaced with +, number without + and code of country
+// is national number of DefaultPhoneCountry. Invalid numbers are returned as *PhoneError.
+func NormalizePhone(phone types.Phone) (types.Phone, error) {
+	raw := string(phone)
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '\t':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+
+	international := false
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits, international = digits[1:], true
+	case strings.HasPrefix(digits, "00"):
+		digits, international = digits[2:], true
+	}
+
+	if digits == "" {
+		return "", &PhoneError{Phone: raw, Reason: "empty number"}
+	}
+
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", &PhoneError{Phone: raw, Reason: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	if !international {
+		country := findPhoneCountry(DefaultPhoneCountry)
+		if len(digits) == country.length {
+			digits = country.code + digits
+		}
+	}
+
+	for _, country := range phoneCountries {
+		if !strings.HasPrefix(digits, country.code) {
+			continue
+		}
+
+		national := digits[len(country.code):]
+		if len(national) != country.length {
+			return "", &PhoneError{Phone: raw, Reason: fmt.Sprintf("number of +%s must have %d digits after code of country", country.code, country.length)}
+		}
+
+		for _, prefix := range country.prefixes {
+			if strings.HasPrefix(national, prefix) {
+				return types.Phone("+" + digits), nil
+			}
+		}
+
+		return "", &PhoneError{Phone: raw, Reason: fmt.Sprintf("unknown operator %s of +%s", national[:2], country.code)}
+	}
+
+	if digits[0] == '0' || len(digits) < 8 || len(digits) > 15 {
+		return "", &PhoneError{Phone: raw, Reason: "number must have from 8 to 15 digits with code of country"}
+	}
+
+	return types.Phone("+" + digits), nil
+}
+
+func findPhoneCountry(code string) phoneCountry {
+	for _, country := range phoneCountries {
+		if country.code == code {
+			return country
+		}
+	}
+
+	return phoneCountry{}
+}
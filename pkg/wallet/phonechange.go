@@ -0,0 +1,164 @@
+package wallet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// PhoneMovedError is returned for old phone of account, errors.Is(err, ErrAccountNotFound) is true for it.
+type PhoneMovedError struct {
+	Phone     types.Phone
+	AccountID int64
+	NewPhone  types.Phone
+	Time      int64
+}
+
+func (e *PhoneMovedError) Error() string {
+	return fmt.Sprintf("%v: phone %s of account %d was changed to %s at %s",
+		ErrAccountNotFound, e.Phone, e.AccountID, e.NewPhone, time.Unix(e.Time, 0).UTC().Format(time.RFC3339))
+}
+
+func (e *PhoneMovedError) Unwrap() error {
+	return ErrAccountNotFound
+}
+
+// phoneMoved returns *PhoneMovedError for the last change from phone or ErrAccountNotFound.
+func (s *Service) phoneMoved(phone types.Phone) error {
+	for i := len(s.phoneChanges) - 1; i >= 0; i-- {
+		change := s.phoneChanges[i]
+		if phoneKey(change.OldPhone) != phoneKey(phone) {
+			continue
+		}
+
+		account, err := s.FindAccountByID(change.AccountID)
+		if err != nil {
+			return err
+		}
+
+		return &PhoneMovedError{Phone: phone, AccountID: account.ID, NewPhone: account.Phone, Time: change.Time}
+	}
+
+	return ErrAccountNotFound
+}
+
+// ChangePhone moves account to phone which isn't registered to any account and records
+// previous phone in history. Money sent to new phone before change is credited to account.
+func (s *Service) ChangePhone(accountID int64, phone types.Phone) (change *types.PhoneChange, err error) {
+	defer func() {
+		s.audit("change-phone", map[string]string{
+			"account": strconv.FormatInt(accountID, 10),
+			"phone":   string(phone),
+		}, "ok", err)
+	}()
+
+	phone, err = NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if accountStatus(account) == types.AccountStatusClosed {
+		return nil, ErrAccountClosed
+	}
+
+	if s.accountByPhone(phone) != nil {
+		return nil, ErrPhoneRegistered
+	}
+
+	change = &types.PhoneChange{
+		AccountID: account.ID,
+		OldPhone:  account.Phone,
+		NewPhone:  phone,
+		Time:      s.now().Unix(),
+	}
+	s.phoneChanges = append(s.phoneChanges, change)
+
+	account.Phone = phone
+	s.indexPhone(account, change.OldPhone)
+	s.publish(types.Event{Type: types.EventPhoneChanged, AccountID: account.ID})
+
+	s.creditClaims(account)
+
+	return change, nil
+}
+
+// PhoneHistory returns copies of phone changes of account from the oldest one.
+func (s *Service) PhoneHistory(accountID int64) ([]types.PhoneChange, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []types.PhoneChange{}
+	for _, change := range s.phoneChanges {
+		if change.AccountID == accountID {
+			changes = append(changes, *change)
+		}
+	}
+
+	return changes, nil
+}
+
+// exportPhoneChanges formats phones.dump: AccountID;OldPhone;NewPhone;Time.
+func (s *Service) exportPhoneChanges() string {
+	result := ""
+	for _, change := range s.phoneChanges {
+		result += strconv.FormatInt(change.AccountID, 10) + ";"
+		result += string(change.OldPhone) + ";"
+		result += string(change.NewPhone) + ";"
+		result += strconv.FormatInt(change.Time, 10) + "\n"
+	}
+
+	return result
+}
+
+// actionByPhoneChanges replaces history of phones by phones.dump.
+func (s *Service) actionByPhoneChanges(path string) error {
+	byteData, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(ErrFileNotFound.Error())
+		return nil
+	}
+
+	changes := []*types.PhoneChange{}
+	for _, line := range strings.Split(string(byteData), "\n") {
+		if line == "" {
+			continue
+		}
+
+		data := strings.Split(line, ";")
+		if len(data) != 4 {
+			return fmt.Errorf("%w: phone change %q", ErrWrongFormat, line)
+		}
+
+		accountID, err := strconv.ParseInt(data[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: phone change %q", ErrWrongFormat, line)
+		}
+
+		changed, err := strconv.ParseInt(data[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: phone change %q", ErrWrongFormat, line)
+		}
+
+		changes = append(changes, &types.PhoneChange{
+			AccountID: accountID,
+			OldPhone:  types.Phone(data[1]),
+			NewPhone:  types.Phone(data[2]),
+			Time:      changed,
+		})
+	}
+
+	s.phoneChanges = changes
+	return nil
+}
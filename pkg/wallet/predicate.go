@@ -0,0 +1,382 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+var ErrBadExpression = errors.New("bad filter expression")
+
+// Predicate selects payments, it can be passed to FilterPaymentsByFn.
+type Predicate func(payment types.Payment) bool
+
+func ByAccount(accountID int64) Predicate {
+	return func(payment types.Payment) bool {
+		return payment.AccountID == accountID
+	}
+}
+
+func ByCategory(categories ...types.PaymentCategory) Predicate {
+	return func(payment types.Payment) bool {
+		for _, category := range categories {
+			if payment.Category == category {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func ByStatus(statuses ...types.PaymentStatus) Predicate {
+	return func(payment types.Payment) bool {
+		for _, status := range statuses {
+			if payment.Status == status {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// AmountBetween selects payments with min <= amount <= max.
+func AmountBetween(min types.Money, max types.Money) Predicate {
+	return func(payment types.Payment) bool {
+		return payment.Amount >= min && payment.Amount <= max
+	}
+}
+
+func And(predicates ...Predicate) Predicate {
+	return func(payment types.Payment) bool {
+		for _, predicate := range predicates {
+			if !predicate(payment) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func Or(predicates ...Predicate) Predicate {
+	return func(payment types.Payment) bool {
+		for _, predicate := range predicates {
+			if predicate(payment) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func Not(predicate Predicate) Predicate {
+	return func(payment types.Payment) bool {
+		return !predicate(payment)
+	}
+}
+
+// ParsePredicate parses expression like `category=Cafe and amount>1.00` with amounts in DefaultLocale.
+//
+// Comparisons are field, operator and value without or with spaces around operator.
+// Fields are account, category, status, id, amount and time. Operators are = and !=
+// for every field and <, <=, >, >= for account, amount and time. Values with spaces
+// are written in double quotes. Comparisons are joined with and, or, not and parentheses,
+// and binds stronger than or.
+//
+// Amounts are read by ParseAmount like 1.50, 150TJS or "1 234.50 TJS", number without decimal
+// separator and currency is rejected, so amount>100 isn't taken for 1.00 or 100.00.
+func ParsePredicate(expression string) (Predicate, error) {
+	return ParsePredicateLocale(expression, DefaultLocale)
+}
+
+// ParsePredicateLocale is ParsePredicate with amounts written in locale.
+func ParsePredicateLocale(expression string, locale Locale) (Predicate, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &predicateParser{tokens: tokens, locale: locale}
+	predicate, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.pos != len(parser.tokens) {
+		return nil, parser.errorf("unexpected %q", parser.tokens[parser.pos].value)
+	}
+
+	return predicate, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOperator
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func isOperatorChar(r rune) bool {
+	return r == '=' || r == '!' || r == '<' || r == '>'
+}
+
+// isWordChar is false for runes which end word: spaces, operators, parentheses and quotes.
+func isWordChar(r rune) bool {
+	return !unicode.IsSpace(r) && !isOperatorChar(r) && r != '(' && r != ')' && r != '"'
+}
+
+// tokenize splits expression by runes, bytes of UTF-8 values like 0x85 and 0xA0
+// aren't spaces. Positions of tokens are byte offsets.
+func tokenize(expression string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(expression); {
+		char, size := utf8.DecodeRuneInString(expression[i:])
+		switch {
+		case unicode.IsSpace(char):
+			i += size
+		case char == '(':
+			tokens = append(tokens, token{kind: tokenOpen, value: "(", pos: i})
+			i++
+		case char == ')':
+			tokens = append(tokens, token{kind: tokenClose, value: ")", pos: i})
+			i++
+		case char == '"':
+			end := strings.IndexByte(expression[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unclosed quote at %d", ErrBadExpression, i)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: expression[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case isOperatorChar(char):
+			start := i
+			for i < len(expression) && isOperatorChar(rune(expression[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenOperator, value: expression[start:i], pos: start})
+		default:
+			start := i
+			for i < len(expression) {
+				r, size := utf8.DecodeRuneInString(expression[i:])
+				if !isWordChar(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: expression[start:i], pos: start})
+		}
+	}
+
+	return tokens, nil
+}
+
+type predicateParser struct {
+	tokens []token
+	pos    int
+	locale Locale
+}
+
+func (p *predicateParser) errorf(format string, args ...interface{}) error {
+	position := -1
+	if p.pos < len(p.tokens) {
+		position = p.tokens[p.pos].pos
+	}
+
+	if position < 0 {
+		return fmt.Errorf("%w: %s at end", ErrBadExpression, fmt.Sprintf(format, args...))
+	}
+
+	return fmt.Errorf("%w: %s at %d", ErrBadExpression, fmt.Sprintf(format, args...), position)
+}
+
+func (p *predicateParser) keyword(word string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenWord && strings.EqualFold(p.tokens[p.pos].value, word) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *predicateParser) parseOr() (Predicate, error) {
+	predicate, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	predicates := []Predicate{predicate}
+	for p.keyword("or") {
+		predicate, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+	}
+
+	if len(predicates) == 1 {
+		return predicates[0], nil
+	}
+
+	return Or(predicates...), nil
+}
+
+func (p *predicateParser) parseAnd() (Predicate, error) {
+	predicate, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	predicates := []Predicate{predicate}
+	for p.keyword("and") {
+		predicate, err = p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+	}
+
+	if len(predicates) == 1 {
+		return predicates[0], nil
+	}
+
+	return And(predicates...), nil
+}
+
+func (p *predicateParser) parseUnary() (Predicate, error) {
+	if p.keyword("not") {
+		predicate, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return Not(predicate), nil
+	}
+
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOpen {
+		p.pos++
+		predicate, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenClose {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+
+		return predicate, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *predicateParser) parseComparison() (Predicate, error) {
+	if p.pos+3 > len(p.tokens) {
+		return nil, p.errorf("expected comparison")
+	}
+
+	field, operator, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	if field.kind != tokenWord {
+		return nil, p.errorf("expected field")
+	}
+	if operator.kind != tokenOperator {
+		p.pos++
+		return nil, p.errorf("expected operator")
+	}
+	if value.kind != tokenWord && value.kind != tokenString {
+		p.pos += 2
+		return nil, p.errorf("expected value")
+	}
+
+	predicate, err := comparison(strings.ToLower(field.value), operator.value, value.value, p.locale)
+	if err != nil {
+		return nil, fmt.Errorf("%w at %d", err, field.pos)
+	}
+
+	p.pos += 3
+	return predicate, nil
+}
+
+func comparison(field string, operator string, value string, locale Locale) (Predicate, error) {
+	switch field {
+	case "category", "status", "id":
+		get := func(payment types.Payment) string {
+			switch field {
+			case "category":
+				return string(payment.Category)
+			case "status":
+				return string(payment.Status)
+			}
+			return payment.ID
+		}
+
+		switch operator {
+		case "=":
+			return func(payment types.Payment) bool { return get(payment) == value }, nil
+		case "!=":
+			return func(payment types.Payment) bool { return get(payment) != value }, nil
+		}
+
+		return nil, fmt.Errorf("%w: operator %s can't be used with %s", ErrBadExpression, operator, field)
+	case "account", "amount", "time":
+		var number int64
+		if field == "amount" {
+			amount, err := ParseAmount(value, locale)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrBadExpression, err)
+			}
+			number = int64(amount)
+		} else {
+			var err error
+			number, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s must be number, got %q", ErrBadExpression, field, value)
+			}
+		}
+
+		get := func(payment types.Payment) int64 {
+			switch field {
+			case "account":
+				return payment.AccountID
+			case "amount":
+				return int64(payment.Amount)
+			}
+			return payment.Time
+		}
+
+		switch operator {
+		case "=":
+			return func(payment types.Payment) bool { return get(payment) == number }, nil
+		case "!=":
+			return func(payment types.Payment) bool { return get(payment) != number }, nil
+		case "<":
+			return func(payment types.Payment) bool { return get(payment) < number }, nil
+		case "<=":
+			return func(payment types.Payment) bool { return get(payment) <= number }, nil
+		case ">":
+			return func(payment types.Payment) bool { return get(payment) > number }, nil
+		case ">=":
+			return func(payment types.Payment) bool { return get(payment) >= number }, nil
+		}
+
+		return nil, fmt.Errorf("%w: unknown operator %s", ErrBadExpression, operator)
+	}
+
+	return nil, fmt.Errorf("%w: unknown field %q", ErrBadExpression, field)
+}
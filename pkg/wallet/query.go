@@ -0,0 +1,206 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+var ErrBadQuery = errors.New("bad query")
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
+)
+
+// cursor points to the last payment of page: value of sort field and id,
+// which breaks ties, so pages stay stable when new payments are added.
+type cursor struct {
+	key int64
+	id  string
+}
+
+// sortOrder is written into cursor, so cursor isn't used with other sort.
+func sortOrder(field types.PaymentSortField, desc bool) string {
+	if desc {
+		return string(field) + "|desc"
+	}
+
+	return string(field) + "|asc"
+}
+
+func encodeCursor(c cursor, field types.PaymentSortField, desc bool) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortOrder(field, desc) + "|" + strconv.FormatInt(c.key, 10) + "|" + c.id))
+}
+
+// decodeCursor returns ErrBadCursor for damaged cursor and cursor of page sorted by other field or direction.
+func decodeCursor(value string, field types.PaymentSortField, desc bool) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor{}, ErrBadCursor
+	}
+
+	parts := strings.SplitN(string(data), "|", 4)
+	if len(parts) != 4 || parts[0]+"|"+parts[1] != sortOrder(field, desc) {
+		return cursor{}, ErrBadCursor
+	}
+
+	key, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return cursor{}, ErrBadCursor
+	}
+
+	return cursor{key: key, id: parts[3]}, nil
+}
+
+func sortKey(payment *types.Payment, field types.PaymentSortField) int64 {
+	switch field {
+	case types.SortByAmount:
+		return int64(payment.Amount)
+	case types.SortByTime:
+		return payment.Time
+	}
+
+	return 0
+}
+
+// less orders payments by key and then by id, desc reverses both.
+func less(a cursor, b cursor, desc bool) bool {
+	if a.key != b.key {
+		return (a.key < b.key) != desc
+	}
+
+	return (a.id < b.id) != desc
+}
+
+func matchPayment(payment *types.Payment, query types.PaymentQuery) bool {
+	if query.AccountID != 0 && payment.AccountID != query.AccountID {
+		return false
+	}
+
+	if query.MinAmount != 0 && payment.Amount < query.MinAmount {
+		return false
+	}
+
+	if query.MaxAmount != 0 && payment.Amount > query.MaxAmount {
+		return false
+	}
+
+	if query.From != 0 && payment.Time < query.From {
+		return false
+	}
+
+	if query.To != 0 && payment.Time >= query.To {
+		return false
+	}
+
+	if !strings.HasPrefix(payment.ID, query.IDPrefix) {
+		return false
+	}
+
+	if len(query.Categories) != 0 {
+		found := false
+		for _, category := range query.Categories {
+			if payment.Category == category {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(query.Statuses) != 0 {
+		found := false
+		for _, status := range query.Statuses {
+			if payment.Status == status {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// QueryPayments returns page of payments matching query in stable order.
+func (s *Service) QueryPayments(query types.PaymentQuery) (types.PaymentPage, error) {
+	switch query.SortBy {
+	case "":
+		query.SortBy = types.SortByTime
+	case types.SortByTime, types.SortByAmount, types.SortByID:
+	default:
+		return types.PaymentPage{}, ErrBadQuery
+	}
+
+	if query.Limit < 0 || query.Limit > MaxPageLimit {
+		return types.PaymentPage{}, ErrBadQuery
+	}
+
+	if query.Limit == 0 {
+		query.Limit = DefaultPageLimit
+	}
+
+	where := Predicate(func(payment types.Payment) bool { return true })
+	if query.Where != "" {
+		predicate, err := ParsePredicate(query.Where)
+		if err != nil {
+			return types.PaymentPage{}, err
+		}
+		where = predicate
+	}
+
+	var after *cursor
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor, query.SortBy, query.Desc)
+		if err != nil {
+			return types.PaymentPage{}, err
+		}
+		after = &c
+	}
+
+	matched := []*types.Payment{}
+	for _, payment := range s.payments {
+		if !matchPayment(payment, query) || !where(*payment) {
+			continue
+		}
+
+		if after != nil && !less(*after, cursor{key: sortKey(payment, query.SortBy), id: payment.ID}, query.Desc) {
+			continue
+		}
+
+		matched = append(matched, payment)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return less(
+			cursor{key: sortKey(matched[i], query.SortBy), id: matched[i].ID},
+			cursor{key: sortKey(matched[j], query.SortBy), id: matched[j].ID},
+			query.Desc,
+		)
+	})
+
+	page := types.PaymentPage{Payments: []types.Payment{}}
+	for i, payment := range matched {
+		if i == query.Limit {
+			last := matched[i-1]
+			page.NextCursor = encodeCursor(cursor{key: sortKey(last, query.SortBy), id: last.ID}, query.SortBy, query.Desc)
+			break
+		}
+
+		page.Payments = append(page.Payments, *payment)
+	}
+
+	return page, nil
+}
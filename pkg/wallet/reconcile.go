@@ -0,0 +1,159 @@
+package wallet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// Reconcile checks consistency of service data: duplicate ids and phones,
+// payments and favorites of unknown accounts, negative balances and
+// balances of accounts, merged accounts and pending transfers which don't match journal,
+// sums which overflow Money. It doesn't change anything.
+func (s *Service) Reconcile() types.ReconcileReport {
+	report := types.ReconcileReport{
+		Accounts:      len(s.accounts),
+		Payments:      len(s.payments),
+		Favorites:     len(s.favorites),
+		Transactions:  len(s.journal),
+		Discrepancies: []types.Discrepancy{},
+	}
+
+	add := func(kind types.DiscrepancyKind, entity string, id string, format string, args ...interface{}) {
+		report.Discrepancies = append(report.Discrepancies, types.Discrepancy{
+			Kind:    kind,
+			Entity:  entity,
+			ID:      id,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	accounts := make(map[int64]bool)
+	phones := make(map[types.Phone]int64)
+	for _, account := range s.accounts {
+		id := strconv.FormatInt(account.ID, 10)
+		if accounts[account.ID] {
+			add(types.DiscrepancyDuplicateID, "account", id, "account id %d is used more than once", account.ID)
+		}
+		accounts[account.ID] = true
+
+		// old dumps may have the same number written in different formats
+		phone, err := NormalizePhone(account.Phone)
+		if err != nil {
+			phone = account.Phone
+		}
+		other, ok := phones[phone]
+		if ok {
+			add(types.DiscrepancyDuplicatePhone, "account", id, "phone %s is also registered to account %d", account.Phone, other)
+		} else {
+			phones[phone] = account.ID
+		}
+
+		if account.Balance < 0 {
+			add(types.DiscrepancyNegativeBalance, "account", id, "balance is %d", account.Balance)
+		}
+	}
+
+	payments := make(map[string]bool)
+	for _, payment := range s.payments {
+		if payments[payment.ID] {
+			add(types.DiscrepancyDuplicateID, "payment", payment.ID, "payment id is used more than once")
+		}
+		payments[payment.ID] = true
+
+		if !accounts[payment.AccountID] {
+			add(types.DiscrepancyOrphanPayment, "payment", payment.ID, "account %d not found", payment.AccountID)
+		}
+	}
+
+	favorites := make(map[string]bool)
+	for _, favorite := range s.favorites {
+		if favorites[favorite.ID] {
+			add(types.DiscrepancyDuplicateID, "favorite", favorite.ID, "favorite id is used more than once")
+		}
+		favorites[favorite.ID] = true
+
+		if !accounts[favorite.AccountID] {
+			add(types.DiscrepancyOrphanFavorite, "favorite", favorite.ID, "account %d not found", favorite.AccountID)
+		}
+	}
+
+	transactions := make(map[string]bool)
+	balances := make(map[types.LedgerAccount]types.Money)
+	for _, transaction := range s.journal {
+		if transactions[transaction.ID] {
+			add(types.DiscrepancyDuplicateID, "transaction", transaction.ID, "transaction id is used more than once")
+		}
+		transactions[transaction.ID] = true
+
+		if checkPostings(transaction.Postings) != nil {
+			add(types.DiscrepancyUnbalancedTransaction, "transaction", transaction.ID, "postings don't sum to zero")
+		}
+
+		for _, posting := range transaction.Postings {
+			balance, err := balances[posting.Account].Add(posting.Amount)
+			if err != nil {
+				add(types.DiscrepancyOverflow, "transaction", transaction.ID, "balance of %s: %v", posting.Account, err)
+			} else {
+				balances[posting.Account] = balance
+			}
+
+			id, ok := walletID(posting.Account)
+			if ok && !accounts[id] && s.findMerge(id) == nil {
+				add(types.DiscrepancyOrphanPosting, "transaction", transaction.ID, "account %d not found", id)
+			}
+		}
+	}
+
+	for _, account := range s.accounts {
+		balance := balances[WalletLedger(account.ID)]
+		if balance != account.Balance {
+			add(types.DiscrepancyBalanceMismatch, "account", strconv.FormatInt(account.ID, 10),
+				"balance is %d, journal gives %d", account.Balance, balance)
+		}
+	}
+
+	for _, merge := range s.merges {
+		balance := balances[WalletLedger(merge.AccountID)]
+		if balance != 0 {
+			add(types.DiscrepancyBalanceMismatch, "account", strconv.FormatInt(merge.AccountID, 10),
+				"account was merged into account %d, journal gives %d", merge.IntoAccountID, balance)
+		}
+	}
+
+	claims := make(map[types.LedgerAccount]types.Money)
+	for _, transfer := range s.transfers {
+		if transfer.Status == types.TransferStatusPending {
+			pending, err := claims[ClaimsLedger(transfer.Phone)].Add(transfer.Amount)
+			if err != nil {
+				add(types.DiscrepancyOverflow, "transfer", transfer.ID, "pending transfers to %s: %v", transfer.Phone, err)
+				continue
+			}
+			claims[ClaimsLedger(transfer.Phone)] = pending
+		}
+	}
+	for ledger := range balances {
+		if _, ok := claims[ledger]; !ok && strings.HasPrefix(string(ledger), claimsLedgerPrefix) {
+			claims[ledger] = 0
+		}
+	}
+
+	ledgers := make([]string, 0, len(claims))
+	for ledger := range claims {
+		ledgers = append(ledgers, string(ledger))
+	}
+	sort.Strings(ledgers)
+
+	for _, ledger := range ledgers {
+		pending, balance := claims[types.LedgerAccount(ledger)], balances[types.LedgerAccount(ledger)]
+		if balance != pending {
+			add(types.DiscrepancyBalanceMismatch, "claims", strings.TrimPrefix(ledger, claimsLedgerPrefix),
+				"pending transfers are %d, journal gives %d", pending, balance)
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,125 @@
+package wallet
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// scanBlockSize is number of items mapped by one call of mapper. Workers check
+// context between blocks, so scans stop soon after cancellation.
+const scanBlockSize = 1024
+
+// Reducer merges partial result of block into accumulated result. Partial results
+// come in order of blocks, so reduced result doesn't depend on scheduling of goroutines.
+// Mapper or reducer returns error instead of result to stop scan with it, like overflow of sum.
+type Reducer func(accumulated interface{}, partial interface{}) interface{}
+
+// PaymentMapper maps block of payments to partial result.
+type PaymentMapper func(payments []types.Payment) interface{}
+
+// AccountMapper maps block of accounts to partial result.
+type AccountMapper func(accounts []types.Account) interface{}
+
+// chunk returns bounds of part i when length items are split into parts of nearly equal size.
+func chunk(length int, parts int, i int) (int, int) {
+	return i * length / parts, (i + 1) * length / parts
+}
+
+// scan splits items [0, length) into blocks, shares blocks between workers and calls
+// mapper for every block. Worker count below 1 means one worker. Results of blocks
+// are passed to reducer in order of blocks starting with initial, the first error
+// returned by mapper or reducer is returned by scan.
+func scan(ctx context.Context, length int, workers int, mapper func(from, to int) interface{}, reducer Reducer, initial interface{}) (interface{}, error) {
+	blocks := (length + scanBlockSize - 1) / scanBlockSize
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > blocks {
+		workers = blocks
+	}
+
+	results := make([]interface{}, blocks)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		from, to := chunk(blocks, workers, i)
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			for block := from; block < to; block++ {
+				if ctx.Err() != nil {
+					return
+				}
+
+				start := block * scanBlockSize
+				end := start + scanBlockSize
+				if end > length {
+					end = length
+				}
+				results[block] = mapper(start, end)
+			}
+		}(from, to)
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	accumulated := initial
+	for _, result := range results {
+		err, ok := result.(error)
+		if ok {
+			return nil, err
+		}
+
+		accumulated = reducer(accumulated, result)
+		err, ok = accumulated.(error)
+		if ok {
+			return nil, err
+		}
+	}
+
+	return accumulated, nil
+}
+
+// ScanPayments maps copies of payments by workers goroutines and reduces partial results in order of payments.
+func (s *Service) ScanPayments(ctx context.Context, workers int, mapper PaymentMapper, reducer Reducer, initial interface{}) (interface{}, error) {
+	payments := s.payments
+	return scan(ctx, len(payments), workers, func(from, to int) interface{} {
+		block := make([]types.Payment, 0, to-from)
+		for _, payment := range payments[from:to] {
+			block = append(block, *payment)
+		}
+		return mapper(block)
+	}, reducer, initial)
+}
+
+// ScanAccounts maps copies of accounts by workers goroutines and reduces partial results in order of accounts.
+func (s *Service) ScanAccounts(ctx context.Context, workers int, mapper AccountMapper, reducer Reducer, initial interface{}) (interface{}, error) {
+	accounts := s.accounts
+	return scan(ctx, len(accounts), workers, func(from, to int) interface{} {
+		block := make([]types.Account, 0, to-from)
+		for _, account := range accounts[from:to] {
+			block = append(block, *account)
+		}
+		return mapper(block)
+	}, reducer, initial)
+}
+
+// SumMoney is Reducer for partial results of type types.Money, overflow gives *types.OverflowError.
+func SumMoney(accumulated interface{}, partial interface{}) interface{} {
+	sum, err := accumulated.(types.Money).Add(partial.(types.Money))
+	if err != nil {
+		return err
+	}
+
+	return sum
+}
+
+// AppendPayments is Reducer for partial results of type []types.Payment.
+func AppendPayments(accumulated interface{}, partial interface{}) interface{} {
+	return append(accumulated.([]types.Payment), partial.([]types.Payment)...)
+}
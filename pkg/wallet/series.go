@@ -0,0 +1,229 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// MaxSeriesPoints limits count of buckets in one series.
+const MaxSeriesPoints = 10_000
+
+// TotalSeriesKey is key of series when payments aren't grouped.
+const TotalSeriesKey = "total"
+
+// bucketStart returns start of day, week (from Monday) or month of moment in UTC.
+func bucketStart(moment time.Time, period types.Period) time.Time {
+	moment = moment.UTC()
+	day := time.Date(moment.Year(), moment.Month(), moment.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case types.PeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case types.PeriodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+
+	return day
+}
+
+func nextBucket(start time.Time, period types.Period) time.Time {
+	switch period {
+	case types.PeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case types.PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	}
+
+	return start.AddDate(0, 0, 1)
+}
+
+// SpendingSeries buckets payments made in [from, to) by period. Zero from and to are taken from
+// the first and the last payment. Payments without time, imported from old dumps, are skipped.
+func (s *Service) SpendingSeries(period types.Period, by types.GroupBy, from, to time.Time) (types.SpendingReport, error) {
+	return s.SpendingSeriesContext(context.Background(), period, by, from, to, nil, 1)
+}
+
+// SpendingSeriesContext is SpendingSeries for payments selected by filter, nil filter selects all payments.
+// Empty by makes single series with key TotalSeriesKey. Every series has all buckets of period,
+// buckets without payments are filled with zeros.
+func (s *Service) SpendingSeriesContext(ctx context.Context, period types.Period, by types.GroupBy, from, to time.Time, filter Predicate, goroutines int) (types.SpendingReport, error) {
+	if period != types.PeriodDay && period != types.PeriodWeek && period != types.PeriodMonth {
+		return types.SpendingReport{}, fmt.Errorf("%w: unknown period %q", ErrBadQuery, period)
+	}
+
+	key := func(payment types.Payment) string { return TotalSeriesKey }
+	if by != "" {
+		var err error
+		key, err = groupKey(by)
+		if err != nil {
+			return types.SpendingReport{}, err
+		}
+	}
+
+	payments := s.payments
+	collected, err := scan(ctx, len(payments), goroutines, func(low, high int) interface{} {
+		buckets := map[string]map[int64]types.SeriesPoint{}
+		for _, payment := range payments[low:high] {
+			if payment.Time == 0 || (filter != nil && !filter(*payment)) {
+				continue
+			}
+
+			// the first and the last buckets may start before from and end after to
+			if (!from.IsZero() && payment.Time < from.Unix()) || (!to.IsZero() && payment.Time >= to.Unix()) {
+				continue
+			}
+
+			name := key(*payment)
+			if buckets[name] == nil {
+				buckets[name] = map[int64]types.SeriesPoint{}
+			}
+
+			start := bucketStart(time.Unix(payment.Time, 0), period).Unix()
+			point := buckets[name][start]
+			total, err := point.Total.Add(payment.Amount)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			point.Start = start
+			point.Total = total
+			point.Count++
+			buckets[name][start] = point
+		}
+		return buckets
+	}, func(accumulated interface{}, partial interface{}) interface{} {
+		buckets := accumulated.(map[string]map[int64]types.SeriesPoint)
+		for name, points := range partial.(map[string]map[int64]types.SeriesPoint) {
+			if buckets[name] == nil {
+				buckets[name] = map[int64]types.SeriesPoint{}
+			}
+			for start, part := range points {
+				point := buckets[name][start]
+				total, err := point.Total.Add(part.Total)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				point.Start = start
+				point.Total = total
+				point.Count += part.Count
+				buckets[name][start] = point
+			}
+		}
+		return buckets
+	}, map[string]map[int64]types.SeriesPoint{})
+	if err != nil {
+		return types.SpendingReport{}, err
+	}
+
+	buckets := collected.(map[string]map[int64]types.SeriesPoint)
+	if len(buckets) == 0 && (from.IsZero() || to.IsZero()) {
+		return types.SpendingReport{Period: period, GroupBy: by, Series: []types.Series{}}, nil
+	}
+
+	if from.IsZero() || to.IsZero() {
+		first, last := int64(0), int64(0)
+		for _, points := range buckets {
+			for start := range points {
+				if first == 0 || start < first {
+					first = start
+				}
+				if start > last {
+					last = start
+				}
+			}
+		}
+
+		if from.IsZero() {
+			from = time.Unix(first, 0)
+		}
+		if to.IsZero() {
+			to = nextBucket(time.Unix(last, 0).UTC(), period)
+		}
+	}
+
+	report := types.SpendingReport{Period: period, GroupBy: by, From: from.Unix(), To: to.Unix(), Series: []types.Series{}}
+
+	starts := []int64{}
+	for start := bucketStart(from, period); start.Before(to); start = nextBucket(start, period) {
+		if len(starts) == MaxSeriesPoints {
+			return types.SpendingReport{}, fmt.Errorf("%w: more than %d %s buckets", ErrBadQuery, MaxSeriesPoints, period)
+		}
+		starts = append(starts, start.Unix())
+	}
+
+	for name, points := range buckets {
+		series := types.Series{Key: name, Points: make([]types.SeriesPoint, 0, len(starts))}
+		for _, start := range starts {
+			point := points[start]
+			point.Start = start
+			series.Points = append(series.Points, point)
+		}
+		report.Series = append(report.Series, series)
+	}
+
+	sort.Slice(report.Series, func(i, j int) bool {
+		if by == types.GroupByAccount {
+			left, _ := strconv.ParseInt(report.Series[i].Key, 10, 64)
+			right, _ := strconv.ParseInt(report.Series[j].Key, 10, 64)
+			return left < right
+		}
+		return report.Series[i].Key < report.Series[j].Key
+	})
+
+	return report, nil
+}
+
+// chartWidth is length of the longest bar of WriteSeriesChart.
+const chartWidth = 40
+
+// WriteSeriesChart draws every series of report as horizontal ASCII bars, one bar per bucket,
+// totals are written in locale.
+func WriteSeriesChart(w io.Writer, report types.SpendingReport, locale Locale) error {
+	layout := "2006-01-02"
+	if report.Period == types.PeriodMonth {
+		layout = "2006-01"
+	}
+
+	for i, series := range report.Series {
+		max := types.Money(0)
+		for _, point := range series.Points {
+			if point.Total > max {
+				max = point.Total
+			}
+		}
+
+		if i > 0 {
+			_, err := fmt.Fprintln(w)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "%s by %s\n", series.Key, report.Period)
+		if err != nil {
+			return err
+		}
+
+		for _, point := range series.Points {
+			width := 0
+			if max > 0 {
+				width = int(float64(point.Total) * chartWidth / float64(max))
+			}
+			if width == 0 && point.Total > 0 {
+				width = 1
+			}
+
+			_, err = fmt.Fprintf(w, "%-10s |%-*s %s\n", time.Unix(point.Start, 0).UTC().Format(layout), chartWidth, strings.Repeat("#", width), FormatMoney(point.Total, locale))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,14 +1,19 @@
 package wallet
 
 import (
+	"context"
 	"io/ioutil"
 	"sync"
 	"io"
 	"strings"
 	"strconv"
 	"os"
+	"runtime"
+	"sort"
 	"log"
+	"time"
 	"errors"
+	"fmt"
 	"github.com/anonimous-arn/wallet/pkg/types"
 	"github.com/google/uuid"
 	
@@ -21,19 +26,68 @@ var ErrPaymentNotFound = errors.New("payment not found")
 var ErrNotEnoughBalance = errors.New("account balance least then amount")
 var ErrFavoriteNotFound = errors.New("favorite payment not found")
 var ErrFileNotFound = errors.New("file not found")
+var ErrBalanceMismatch = errors.New("account balance doesn't match ledger")
+var ErrTransactionNotFound = errors.New("transaction not found")
+var ErrUnbalancedTransaction = errors.New("transaction postings don't sum to zero")
+var ErrWrongFormat = errors.New("wrong dump format")
+
+const DefaultDepositSource = "cash"
 
 type Service struct {
 	nextAccountID int64
 	accounts      []*types.Account
 	payments      []*types.Payment
 	favorites     []*types.Favorite
+	journal       []*types.Transaction
+	balances      map[types.LedgerAccount]types.Money
+	clock         func() time.Time
+	actor         string
+	auditLog      *AuditLog
+	events        eventBus
+	phones        map[types.Phone]*types.Account
+	transfers     []*types.Transfer
+	claimTTL      time.Duration
+	phoneChanges  []*types.PhoneChange
+	merges        []*types.AccountMerge
+}
+
+// importOptions tells how dumps are read by one call of Import.
+type importOptions struct {
+	// raw keeps every row of dumps as it is, even if its id is already loaded,
+	// and doesn't post opening entries, so Reconcile reports what is wrong with dumps.
+	raw bool
+	// locale of amounts written for people, nil if amounts are counts of minor units.
+	locale *Locale
 }
 
+func (s *Service) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+
+	return time.Now()
+}
+
+// RegisterAccount registers account for phone in E.164, phone is normalized by NormalizePhone.
+// Money sent to phone before registration is credited to new account unless claim is expired.
 func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneRegistered
-		}
+	account, err := s.registerAccount(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	s.creditClaims(account)
+	return account, nil
+}
+
+func (s *Service) registerAccount(phone types.Phone) (*types.Account, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.accountByPhone(phone) != nil {
+		return nil, ErrPhoneRegistered
 	}
 
 	s.nextAccountID++
@@ -41,52 +95,121 @@ func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
 		ID:      s.nextAccountID,
 		Phone:   phone,
 		Balance: 0,
+		Status:  types.AccountStatusActive,
 	}
 	s.accounts = append(s.accounts, account)
+	s.indexPhone(account, "")
+	s.publish(types.Event{Type: types.EventAccountRegistered, AccountID: account.ID})
 	return account, nil
 }
 
 func (s *Service) Deposit(accountID int64, amount types.Money) error {
+	_, err := s.DepositFrom(accountID, amount, DefaultDepositSource)
+	return err
+}
+
+// DepositFrom credits account and posts deposit transaction with source of money (cash, card, transfer...).
+func (s *Service) DepositFrom(accountID int64, amount types.Money, source string) (transaction *types.Transaction, err error) {
+	defer func() {
+		result := ""
+		if transaction != nil {
+			result = transaction.ID
+		}
+		s.audit("deposit", map[string]string{
+			"account": strconv.FormatInt(accountID, 10),
+			"amount":  strconv.FormatInt(int64(amount), 10),
+			"source":  source,
+		}, result, err)
+	}()
+
 	if amount <= 0 {
-		return ErrAmountMustBePositive
+		return nil, ErrAmountMustBePositive
+	}
+
+	err = checkLedgerName("source", source)
+	if err != nil {
+		return nil, err
 	}
 
 	account, err := s.FindAccountByID(accountID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	account.Balance += amount
-	return nil
+	err = checkActive(account)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err = s.post(types.EntryTypeDeposit, "", source,
+		types.Posting{Account: WalletLedger(account.ID), Amount: amount},
+		types.Posting{Account: DepositsLedger(source), Amount: -amount},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(types.Event{Type: types.EventDeposit, AccountID: account.ID, Amount: amount})
+	return transaction, nil
 }
 
-func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (payment *types.Payment, err error) {
+	defer func() {
+		result := ""
+		if payment != nil {
+			result = payment.ID
+		}
+		s.audit("pay", map[string]string{
+			"account":  strconv.FormatInt(accountID, 10),
+			"amount":   strconv.FormatInt(int64(amount), 10),
+			"category": string(category),
+		}, result, err)
+	}()
+
 	if amount <= 0 {
 		return nil, ErrAmountMustBePositive
 	}
 
+	err = checkLedgerName("category", string(category))
+	if err != nil {
+		return nil, err
+	}
+
 	account, err := s.FindAccountByID(accountID)
 	if err != nil {
 		return nil, err
 	}
 
-	if account.Balance < amount {
-		return nil, ErrNotEnoughBalance
+	err = checkActive(account)
+	if err != nil {
+		return nil, err
 	}
 
-	account.Balance -= amount
+	if s.balances[WalletLedger(account.ID)] < amount {
+		return nil, ErrNotEnoughBalance
+	}
 
 	paymentID := uuid.New().String()
 
-	payment := &types.Payment{
+	payment = &types.Payment{
 		ID:        paymentID,
 		AccountID: accountID,
 		Amount:    amount,
 		Category:  category,
 		Status:    types.PaymentStatusInProgress,
+		Time:      s.now().Unix(),
+	}
+
+	_, err = s.post(types.EntryTypePayment, paymentID, string(category),
+		types.Posting{Account: WalletLedger(accountID), Amount: -amount},
+		types.Posting{Account: CategoryLedger(category), Amount: amount},
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	s.payments = append(s.payments, payment)
+	s.publishPayment(types.EventPaymentCreated, payment, "")
 
 	return payment, nil
 }
@@ -102,12 +225,58 @@ func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
 	}
 
 	if account == nil {
-		return nil, ErrAccountNotFound
+		return nil, s.accountMerged(accountID)
+	}
+
+	return account, nil
+}
+
+// FindAccountByPhone finds account by phone written in any format accepted by NormalizePhone.
+// Old phone of account which changed it gives *PhoneMovedError.
+func (s *Service) FindAccountByPhone(phone types.Phone) (*types.Account, error) {
+	normalized, err := NormalizePhone(phone)
+	if err == nil {
+		phone = normalized
+	}
+
+	account := s.accountByPhone(phone)
+	if account == nil {
+		return nil, s.phoneMoved(phone)
 	}
 
 	return account, nil
 }
 
+// Accounts returns copies of all accounts.
+func (s *Service) Accounts() []types.Account {
+	accounts := make([]types.Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, *account)
+	}
+
+	return accounts
+}
+
+// Payments returns copies of all payments.
+func (s *Service) Payments() []types.Payment {
+	payments := make([]types.Payment, 0, len(s.payments))
+	for _, payment := range s.payments {
+		payments = append(payments, *payment)
+	}
+
+	return payments
+}
+
+// Favorites returns copies of all favorites.
+func (s *Service) Favorites() []types.Favorite {
+	favorites := make([]types.Favorite, 0, len(s.favorites))
+	for _, favorite := range s.favorites {
+		favorites = append(favorites, *favorite)
+	}
+
+	return favorites
+}
+
 func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
 	for _, payment := range s.payments {
 		if payment.ID == paymentID {
@@ -118,7 +287,11 @@ func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
 	return nil, ErrPaymentNotFound
 }
 
-func (s *Service) Reject(paymentID string) error {
+func (s *Service) Reject(paymentID string) (err error) {
+	defer func() {
+		s.audit("reject", map[string]string{"payment": paymentID}, paymentID, err)
+	}()
+
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return err
@@ -129,23 +302,47 @@ func (s *Service) Reject(paymentID string) error {
 		return err
 	}
 
-	account.Balance += payment.Amount
-	payment.Amount = 0
+	if accountStatus(account) == types.AccountStatusClosed {
+		return ErrAccountClosed
+	}
+
+	if payment.Amount > 0 {
+		_, err = s.post(types.EntryTypeRefund, payment.ID, "",
+			types.Posting{Account: WalletLedger(account.ID), Amount: payment.Amount},
+			types.Posting{Account: RefundsLedger, Amount: -payment.Amount},
+		)
+		if err != nil {
+			return err
+		}
+	}
 	payment.Status = types.PaymentStatusFail
+	// subscribers get amount which was returned, payment itself keeps zero
+	s.publishPayment(types.EventPaymentRejected, payment, "")
+	payment.Amount = 0
 	return nil
 }
 
-func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
+func (s *Service) Repeat(paymentID string) (newPayment *types.Payment, err error) {
+	defer func() {
+		result := ""
+		if newPayment != nil {
+			result = newPayment.ID
+		}
+		s.audit("repeat", map[string]string{"payment": paymentID}, result, err)
+	}()
+
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return nil, err
 	}
 
-	newPayment, err := s.Pay(payment.AccountID, payment.Amount, payment.Category)
+	newPayment, err = s.Pay(payment.AccountID, payment.Amount, payment.Category)
 	if err != nil {
 		return nil, err
 	}
 
+	s.publishPayment(types.EventPaymentRepeated, newPayment, payment.ID)
+
 	return newPayment, nil
 }
 
@@ -164,6 +361,9 @@ func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorit
 	}
 
 	s.favorites = append(s.favorites, favorite)
+
+	copied := *favorite
+	s.publish(types.Event{Type: types.EventFavoriteCreated, AccountID: favorite.AccountID, Amount: favorite.Amount, Favorite: &copied})
 	return favorite, nil
 }
 
@@ -257,18 +457,29 @@ func (s *Service) ImportFromFile(path string) error {
 	return nil
 }
 func (s *Service) Export(dir string) error {
+	return s.ExportContext(context.Background(), dir)
+}
+
+// ExportContext is Export which can be cancelled. Dumps are written to temporary files
+// and renamed only when all of them are ready, so cancelled or failed export leaves
+// files of previous export untouched.
+func (s *Service) ExportContext(ctx context.Context, dir string) error {
+	dumps := map[string]string{}
+
 	if s.accounts != nil {
 		result := ""
 		for _, account := range s.accounts {
 			result += strconv.Itoa(int(account.ID)) + ";"
 			result += string(account.Phone) + ";"
-			result += strconv.Itoa(int(account.Balance)) + "\n"
+			result += strconv.Itoa(int(account.Balance)) + ";"
+			result += string(accountStatus(account)) + "\n"
 		}
 
-		err := actionByFile(dir+"/accounts.dump", result)
-		if err != nil {
-			return err
-		}
+		dumps[dir+"/accounts.dump"] = result
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
 	if s.payments != nil {
@@ -278,13 +489,15 @@ func (s *Service) Export(dir string) error {
 			result += strconv.Itoa(int(payment.AccountID)) + ";"
 			result += strconv.Itoa(int(payment.Amount)) + ";"
 			result += string(payment.Category) + ";"
-			result += string(payment.Status) + "\n"
+			result += string(payment.Status) + ";"
+			result += strconv.FormatInt(payment.Time, 10) + "\n"
 		}
 
-		err := actionByFile(dir+"/payments.dump", result)
-		if err != nil {
-			return err
-		}
+		dumps[dir+"/payments.dump"] = result
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
 	if s.favorites != nil {
@@ -297,38 +510,190 @@ func (s *Service) Export(dir string) error {
 			result += string(favorite.Category) + "\n"
 		}
 
-		err := actionByFile(dir+"/favorites.dump", result)
-		if err != nil {
-			return err
+		dumps[dir+"/favorites.dump"] = result
+	}
+
+	if s.transfers != nil {
+		dumps[dir+"/transfers.dump"] = s.exportTransfers()
+	}
+
+	if s.phoneChanges != nil {
+		dumps[dir+"/phones.dump"] = s.exportPhoneChanges()
+	}
+
+	if s.merges != nil {
+		dumps[dir+"/merges.dump"] = s.exportMerges()
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if s.journal != nil {
+		result := ""
+		for _, transaction := range s.journal {
+			result += transaction.ID + ";"
+			result += string(transaction.Type) + ";"
+			result += transaction.PaymentID + ";"
+			result += transaction.Source + ";"
+			result += strconv.FormatInt(transaction.Time, 10) + ";"
+			for i, posting := range transaction.Postings {
+				if i > 0 {
+					result += ","
+				}
+				result += string(posting.Account) + "=" + strconv.Itoa(int(posting.Amount))
+			}
+			result += "\n"
 		}
+
+		dumps[dir+"/journal.dump"] = result
 	}
 
-	return nil
+	return writeDumps(ctx, dumps)
+}
+
+func (s *Service) Import(dir string) (err error) {
+	return s.ImportContext(context.Background(), dir)
 }
 
-func (s *Service) Import(dir string) error {
-	err := s.actionByAccounts(dir + "/accounts.dump")
+// ImportContext is Import which checks ctx between dump files. Files read before
+// cancellation stay loaded into service. Phones of accounts are normalized to E.164, invalid phone
+// is error of format and accounts with the same phone are returned as *PhoneConflictError after all dumps are loaded.
+func (s *Service) ImportContext(ctx context.Context, dir string) (err error) {
+	return s.importContext(ctx, dir, importOptions{})
+}
+
+func (s *Service) importContext(ctx context.Context, dir string, options importOptions) (err error) {
+	defer func() {
+		s.audit("import", map[string]string{"dir": dir}, "ok", err)
+	}()
+
+	conflicts, err := s.actionByAccounts(dir+"/accounts.dump", options)
 	if err != nil {
 		log.Println("err from actionByAccount")
 		return err
 	}
 
-	err = s.actionByPayments(dir + "/payments.dump")
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = s.actionByPayments(dir+"/payments.dump", options)
 	if err != nil {
 		log.Println("err from actionByPayments")
 		return err
 	}
 
-	err = s.actionByFavorites(dir + "/favorites.dump")
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = s.actionByFavorites(dir+"/favorites.dump", options)
 	if err != nil {
 		log.Println("err from actionByFavorites")
 		return err
 	}
 
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = s.actionByPhoneChanges(dir + "/phones.dump")
+	if err != nil {
+		log.Println("err from actionByPhoneChanges")
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = s.actionByMerges(dir + "/merges.dump")
+	if err != nil {
+		log.Println("err from actionByMerges")
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = s.actionByTransfers(dir + "/transfers.dump")
+	if err != nil {
+		log.Println("err from actionByTransfers")
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// journal and entries may have the same transactions, ids are indexed once for both
+	known := s.transactionIDs()
+	err = s.actionByJournal(dir+"/journal.dump", known, options)
+	if err != nil {
+		log.Println("err from actionByJournal")
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = s.actionByEntries(dir+"/entries.dump", known)
+	if err != nil {
+		log.Println("err from actionByEntries")
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// raw import keeps duplicates for Reconcile, it reports them
+	if options.raw {
+		return nil
+	}
+
+	err = s.addOpeningEntries()
+	if err != nil {
+		log.Println("err from addOpeningEntries")
+		return err
+	}
+
+	if len(conflicts) != 0 {
+		return &PhoneConflictError{Conflicts: conflicts}
+	}
+
 	return nil
 }
 
-func (s *Service) actionByAccounts(path string) error {
+// ImportRaw is Import which keeps dumps as they are, see ImportRawContext.
+func (s *Service) ImportRaw(dir string) error {
+	return s.ImportRawContext(context.Background(), dir)
+}
+
+// ImportRawContext is ImportContext which keeps every row of accounts, payments, favorites and journal,
+// even if its id is already loaded, and doesn't post opening entries for balances of accounts
+// which differ from journal, so Reconcile reports such rows and balances. Use it to check dumps, not to serve them.
+func (s *Service) ImportRawContext(ctx context.Context, dir string) error {
+	return s.importContext(ctx, dir, importOptions{raw: true})
+}
+
+// ImportHuman is Import of dumps edited by people, see ImportHumanContext.
+func (s *Service) ImportHuman(dir string, locale Locale) error {
+	return s.ImportHumanContext(context.Background(), dir, locale)
+}
+
+// ImportHumanContext is ImportContext of dumps where balances of accounts and amounts of payments
+// and favorites are written in locale like 1 234.50 TJS. Other dumps are read as usual.
+func (s *Service) ImportHumanContext(ctx context.Context, dir string, locale Locale) error {
+	return s.importContext(ctx, dir, importOptions{locale: &locale})
+}
+
+// actionByAccounts loads accounts and returns accounts whose phone is already registered to other account.
+func (s *Service) actionByAccounts(path string, options importOptions) ([]PhoneConflict, error) {
+	conflicts := []PhoneConflict{}
 	byteData, err := ioutil.ReadFile(path)
 	if err == nil {
 		datas := string(byteData)
@@ -344,39 +709,67 @@ func (s *Service) actionByAccounts(path string) error {
 			id, err := strconv.Atoi(data[0])
 			if err != nil {
 				log.Println("can't parse str to int")
-				return err
+				return nil, err
 			}
 
-			phone := types.Phone(data[1])
+			// old dumps may have the same number in different formats
+			phone, err := NormalizePhone(types.Phone(data[1]))
+			if err != nil {
+				return nil, fmt.Errorf("%w: account %d: %v", ErrWrongFormat, id, err)
+			}
 
-			balance, err := strconv.Atoi(data[2])
+			balance, err := options.parseAmount(data[2])
 			if err != nil {
-				log.Println("can't parse str to int")
-				return err
+				log.Println("can't parse amount")
+				return nil, err
+			}
+
+			status := types.AccountStatusActive
+			if len(data) > 3 {
+				status = types.AccountStatus(data[3])
+				if status != types.AccountStatusActive && status != types.AccountStatusFrozen && status != types.AccountStatusClosed {
+					log.Println("unknown status of account")
+					return nil, fmt.Errorf("%w: unknown status %q of account %d", ErrWrongFormat, data[3], id)
+				}
 			}
 
 			account, err := s.FindAccountByID(int64(id))
-			if err != nil {
-				acc, err := s.RegisterAccount(phone)
-				if err != nil {
-					log.Println("err from register account")
-					return err
+			if err != nil || options.raw {
+				// duplicates are kept, Reconcile reports them and MergeAccounts removes them
+				other := s.accountByPhone(phone)
+				if other != nil {
+					conflicts = append(conflicts, PhoneConflict{Phone: phone, AccountID: int64(id), OtherAccountID: other.ID})
 				}
 
-				acc.Balance = types.Money(balance)
+				acc := &types.Account{ID: int64(id), Phone: phone, Balance: types.Money(balance), Status: status}
+				s.accounts = append(s.accounts, acc)
+				s.indexPhone(acc, "")
+
+				// ids of merged accounts aren't reused, so dump may have gaps in ids
+				if s.nextAccountID < acc.ID {
+					s.nextAccountID = acc.ID
+				}
 			} else {
+				other := s.accountByPhone(phone)
+				if other != nil && other != account {
+					conflicts = append(conflicts, PhoneConflict{Phone: phone, AccountID: account.ID, OtherAccountID: other.ID})
+				}
+
+				oldPhone := account.Phone
 				account.Phone = phone
+				s.indexPhone(account, oldPhone)
 				account.Balance = types.Money(balance)
+				account.Status = status
 			}
 		}
 	} else {
 		log.Println(ErrFileNotFound.Error())
 	}
 
-	return nil
+	return conflicts, nil
 }
 
-func (s *Service) actionByPayments(path string) error {
+func (s *Service) actionByPayments(path string, options importOptions) error {
 	byteData, err := ioutil.ReadFile(path)
 	if err == nil {
 		datas := string(byteData)
@@ -396,9 +789,9 @@ func (s *Service) actionByPayments(path string) error {
 				return err
 			}
 
-			amount, err := strconv.Atoi(data[2])
+			amount, err := options.parseAmount(data[2])
 			if err != nil {
-				log.Println("can't parse str to int")
+				log.Println("can't parse amount")
 				return err
 			}
 
@@ -406,14 +799,24 @@ func (s *Service) actionByPayments(path string) error {
 
 			status := types.PaymentStatus(data[4])
 
+			var unix int64
+			if len(data) > 5 {
+				unix, err = strconv.ParseInt(data[5], 10, 64)
+				if err != nil {
+					log.Println("can't parse str to int")
+					return err
+				}
+			}
+
 			payment, err := s.FindPaymentByID(id)
-			if err != nil {
+			if err != nil || options.raw {
 				newPayment := &types.Payment{
 					ID:        id,
 					AccountID: int64(accountID),
 					Amount:    types.Money(amount),
 					Category:  types.PaymentCategory(category),
 					Status:    types.PaymentStatus(status),
+					Time:      unix,
 				}
 
 				s.payments = append(s.payments, newPayment)
@@ -422,6 +825,9 @@ func (s *Service) actionByPayments(path string) error {
 				payment.Amount = types.Money(amount)
 				payment.Category = category
 				payment.Status = status
+				if len(data) > 5 {
+					payment.Time = unix
+				}
 			}
 		}
 	} else {
@@ -431,7 +837,7 @@ func (s *Service) actionByPayments(path string) error {
 	return nil
 }
 
-func (s *Service) actionByFavorites(path string) error {
+func (s *Service) actionByFavorites(path string, options importOptions) error {
 	byteData, err := ioutil.ReadFile(path)
 	if err == nil {
 		datas := string(byteData)
@@ -453,16 +859,16 @@ func (s *Service) actionByFavorites(path string) error {
 
 			name := data[2]
 
-			amount, err := strconv.Atoi(data[3])
+			amount, err := options.parseAmount(data[3])
 			if err != nil {
-				log.Println("can't parse str to int")
+				log.Println("can't parse amount")
 				return err
 			}
 
 			category := types.PaymentCategory(data[4])
 
 			favorite, err := s.FindFavoriteByID(id)
-			if err != nil {
+			if err != nil || options.raw {
 				newFavorite := &types.Favorite{
 					ID:        id,
 					AccountID: int64(accountID),
@@ -519,6 +925,60 @@ func actionByFile(path, data string) error {
 	return nil
 }
 
+// writeDumps writes every dump to temporary file and then renames temporary files
+// to dumps. Temporary files are removed if ctx is done or writing fails.
+func writeDumps(ctx context.Context, dumps map[string]string) (err error) {
+	paths := make([]string, 0, len(dumps))
+	for path := range dumps {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	written := []string{}
+	defer func() {
+		if err != nil {
+			for _, path := range written {
+				os.Remove(path + ".tmp")
+			}
+		}
+	}()
+
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err = actionByFile(path+".tmp", dumps[path])
+		written = append(written, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for i, path := range paths {
+		err = os.Rename(path+".tmp", path)
+		if err != nil {
+			log.Println(err)
+			written = paths[i:]
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cancelled is checked by workers for every cancelCheckInterval payments,
+// so scans stop soon after ctx is done without checking it on every payment.
+func cancelled(ctx context.Context, index int) bool {
+	return index%cancelCheckInterval == 0 && ctx.Err() != nil
+}
+
+const cancelCheckInterval = 1024
+
 func (s *Service) ExportAccountHistory(accountID int64) (payments []types.Payment, err error) {
 	_, err = s.FindAccountByID(accountID)
 	if err != nil {
@@ -555,7 +1015,8 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 			result += strconv.Itoa(int(payment.AccountID)) + ";"
 			result += strconv.Itoa(int(payment.Amount)) + ";"
 			result += string(payment.Category) + ";"
-			result += string(payment.Status) + "\n"
+			result += string(payment.Status) + ";"
+			result += strconv.FormatInt(payment.Time, 10) + "\n"
 		}
 
 		err := actionByFile(dir+"/payments.dump", result)
@@ -573,7 +1034,8 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 		result += strconv.Itoa(int(payment.AccountID)) + ";"
 		result += strconv.Itoa(int(payment.Amount)) + ";"
 		result += string(payment.Category) + ";"
-		result += string(payment.Status) + "\n"
+		result += string(payment.Status) + ";"
+		result += strconv.FormatInt(payment.Time, 10) + "\n"
 
 		if (i+1)%records == 0 {
 			err := actionByFile(dir+"/payments"+strconv.Itoa(k)+".dump", result)
@@ -595,100 +1057,49 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 	return nil
 }
 
+// SumPayments returns sum of all payments, it is 0 when sum overflows Money.
+//
+// Deprecated: overflow can't be told from zero sum, use SumPaymentsContext which returns it.
 func (s *Service) SumPayments(goroutines int) types.Money {
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-	var summ types.Money = 0
-	if goroutines == 0 || goroutines == 1 {
-		wg.Add(1)
-		go func(payments []*types.Payment) {
-			defer wg.Done()
-			for _, payment := range payments {
-				summ += payment.Amount
-			}
-		}(s.payments)
-	} else {
-		from := 0
-		count := len(s.payments) / goroutines
-		for i := 1; i <= goroutines; i++ {
-			wg.Add(1)
-			last := len(s.payments) - i*count
-			if i == goroutines {
-				last = 0
-			}
-			to := len(s.payments) - last
-			go func(payments []*types.Payment) {
-				defer wg.Done()
-				s := types.Money(0)
-				for _, payment := range payments {
-					s += payment.Amount
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				summ += s
-			}(s.payments[from:to])
-			from += count
-		}
+	summ, err := s.SumPaymentsContext(context.Background(), goroutines)
+	if err != nil {
+		log.Println(err)
 	}
-
-	wg.Wait()
-
 	return summ
 }
 
-func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
-	filteredPayments := []types.Payment{}
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-	if goroutines == 0 || goroutines == 1 {
-		wg.Add(1)
-		go func(payments []*types.Payment) {
-			defer wg.Done()
-			for _, payment := range payments {
-				if payment.AccountID == accountID {
-					filteredPayments = append(filteredPayments, types.Payment{
-						ID:        payment.ID,
-						AccountID: payment.AccountID,
-						Amount:    payment.Amount,
-						Category:  payment.Category,
-						Status:    payment.Status,
-					})
-				}
-			}
-		}(s.payments)
-	} else {
-		from := 0
-		count := len(s.payments) / goroutines
-		for i := 1; i <= goroutines; i++ {
-			wg.Add(1)
-			last := len(s.payments) - i*count
-			if i == goroutines {
-				last = 0
+// SumPaymentsContext is SumPayments which stops workers when ctx is done and returns ctx.Err().
+// Overflow of sum is returned as *types.OverflowError.
+func (s *Service) SumPaymentsContext(ctx context.Context, goroutines int) (types.Money, error) {
+	payments := s.payments
+	summ, err := scan(ctx, len(payments), goroutines, func(from, to int) interface{} {
+		sum := types.Money(0)
+		for _, payment := range payments[from:to] {
+			var err error
+			sum, err = sum.Add(payment.Amount)
+			if err != nil {
+				return err
 			}
-			to := len(s.payments) - last
-			go func(payments []*types.Payment) {
-				defer wg.Done()
-				separetePayments := []types.Payment{}
-				for _, payment := range payments {
-					if payment.AccountID == accountID {
-						separetePayments = append(separetePayments, types.Payment{
-							ID:        payment.ID,
-							AccountID: payment.AccountID,
-							Amount:    payment.Amount,
-							Category:  payment.Category,
-							Status:    payment.Status,
-						})
-					}
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				filteredPayments = append(filteredPayments, separetePayments...)
-			}(s.payments[from:to])
-			from += count
 		}
+		return sum
+	}, SumMoney, types.Money(0))
+	if err != nil {
+		return 0, err
 	}
 
-	wg.Wait()
+	return summ.(types.Money), nil
+}
+
+func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
+	return s.FilterPaymentsContext(context.Background(), accountID, goroutines)
+}
+
+// FilterPaymentsContext is FilterPayments which stops workers when ctx is done and returns ctx.Err().
+func (s *Service) FilterPaymentsContext(ctx context.Context, accountID int64, goroutines int) ([]types.Payment, error) {
+	filteredPayments, err := s.FilterPaymentsByFnContext(ctx, ByAccount(accountID), goroutines)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(filteredPayments) == 0 {
 		return nil, ErrAccountNotFound
@@ -697,104 +1108,138 @@ func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payme
 	return filteredPayments, nil
 }
 func (s *Service) FilterPaymentsByFn(filter func(payment types.Payment) bool, goroutines int,) ([]types.Payment, error){
+	return s.FilterPaymentsByFnContext(context.Background(), filter, goroutines)
+}
 
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-	kol := 0
-	i := 0
-	var ps []types.Payment
-	if goroutines == 0 {
-		kol = len(s.payments)
-	} else {
-		kol = int(len(s.payments) / goroutines)
-	}
-	for i = 0; i < goroutines-1; i++ {
-		wg.Add(1)
-		go func(index int) {
-			defer wg.Done()
-			var pays []types.Payment
-			payments := s.payments[index*kol : (index+1)*kol]
-			for _, v := range payments {
-				p := types.Payment{
-					ID:        v.ID,
-					AccountID: v.AccountID,
-					Amount:    v.Amount,
-					Category:  v.Category,
-					Status:    v.Status,
-				}
-
-				if filter(p) {
-					pays = append(pays, p)
-				}
-			}
-			mu.Lock()
-			ps = append(ps, pays...)
-			mu.Unlock()
-
-		}(i)
-	}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+// FilterPaymentsByFnContext is FilterPaymentsByFn which stops workers when ctx is done and returns ctx.Err().
+// Payments are returned in order of creation for any number of goroutines.
+func (s *Service) FilterPaymentsByFnContext(ctx context.Context, filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
+	ps, err := s.ScanPayments(ctx, goroutines, func(payments []types.Payment) interface{} {
 		var pays []types.Payment
-		payments := s.payments[i*kol:]
-		for _, v := range payments {
-
-			p := types.Payment{
-				ID:        v.ID,
-				AccountID: v.AccountID,
-				Amount:    v.Amount,
-				Category:  v.Category,
-				Status:    v.Status,
-			}
-
+		for _, p := range payments {
 			if filter(p) {
 				pays = append(pays, p)
 			}
 		}
-		mu.Lock()
-		ps = append(ps, pays...)
-		mu.Unlock()
-
-	}()
-	wg.Wait()
-	if len(ps) == 0{
+		return pays
+	}, AppendPayments, []types.Payment(nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(ps.([]types.Payment)) == 0 {
 		return nil, nil
 	}
-	return  ps, nil
+	return ps.([]types.Payment), nil
 }
-//SumPaymentsWithProgress делит платежи на куски по 100_000 платежей в каждом и суммирует их параллельно друг другу
+// DefaultProgressChunk is count of payments in one part of SumPaymentsWithProgress.
+const DefaultProgressChunk = 100_000
+
+// SumPaymentsWithProgress делит платежи на куски по DefaultProgressChunk платежей в каждом и суммирует их параллельно друг другу
 func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
-	sizeOfUnit := 100_0000 		/* когда условие и требование в задаче не совпадают :) */
+	return s.SumPaymentsWithProgressContext(context.Background(), DefaultProgressChunk)
+}
 
-	wg := sync.WaitGroup{}
-	goroutines := len(s.payments) / sizeOfUnit /* определяем количество горутин - сколько кусков потребуется сложить*/
-	if goroutines <= 1 {
-		goroutines = 1	
-	/* на случай если платеж всего один (или их нет) */
+// SumPaymentsWithProgressContext splits payments into parts of chunkSize payments and sums parts
+// in parallel, chunkSize below 1 means DefaultProgressChunk. Every part is reported once, in order
+// of completion, and the last progress has Done set, without payments it is the only progress.
+// When ctx is done channel is closed without remaining parts, so reader should check ctx.Err() after it.
+// Overflow of sum is reported by the last progress with Err set.
+func (s *Service) SumPaymentsWithProgressContext(ctx context.Context, chunkSize int) <-chan types.Progress {
+	if chunkSize < 1 {
+		chunkSize = DefaultProgressChunk
 	}
-	ch := make(chan types.Progress)
 
-	for i := 0; i < goroutines; i++ {
+	payments := s.payments
+	total := len(payments)
+	parts := (total + chunkSize - 1) / chunkSize
+	workers := runtime.GOMAXPROCS(0)
+	if workers > parts {
+		workers = parts
+	}
+
+	indexes := make(chan int, parts)
+	for i := 0; i < parts; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	results := make(chan types.Progress)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go func(ch chan <- types.Progress, payments []*types.Payment) {
-			//defer close(ch)
-			var sum types.Money = 0
+		go func() {
 			defer wg.Done()
-			for _, pay := range payments {
-				sum += pay.Amount
-			}
-			ch <- types.Progress{
-				Part:   len(payments), 
-				Result: sum,
+			for index := range indexes {
+				from := index * chunkSize
+				to := from + chunkSize
+				if to > total {
+					to = total
+				}
+
+				var sum types.Money = 0
+				var err error
+				for i, payment := range payments[from:to] {
+					if cancelled(ctx, i) {
+						return
+					}
+					sum, err = sum.Add(payment.Amount)
+					if err != nil {
+						break
+					}
+				}
+
+				select {
+				case results <- types.Progress{Part: to - from, Result: sum, Err: err}:
+				case <-ctx.Done():
+					return
+				}
 			}
-		}(ch, s.payments)
+		}()
 	}
 
 	go func() {
-		defer close(ch)
 		wg.Wait()
+		close(results)
+	}()
+
+	ch := make(chan types.Progress)
+	go func() {
+		defer close(ch)
+
+		progress := types.Progress{Total: total, Percent: 100, Done: total == 0}
+		for result := range results {
+			if progress.Err != nil {
+				// drains results, so workers aren't blocked
+				continue
+			}
+
+			sum, err := progress.Sum.Add(result.Result)
+			if result.Err != nil {
+				err = result.Err
+			}
+
+			progress.Part = result.Part
+			progress.Result = result.Result
+			progress.Processed += result.Part
+			progress.Sum = sum
+			progress.Percent = float64(progress.Processed) * 100 / float64(total)
+			progress.Done = progress.Processed == total
+			progress.Err = err
+
+			select {
+			case ch <- progress:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if total == 0 && ctx.Err() == nil {
+			select {
+			case ch <- progress:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	return ch
-} 
+}
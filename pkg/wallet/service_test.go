@@ -1,7 +1,16 @@
 package wallet
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 	"os"
 	"fmt"
 	"github.com/anonimous-arn/wallet/pkg/types"
@@ -24,7 +33,7 @@ type testAccount struct {
 }
 
 var defaultTestAccount = testAccount{
-	phone:		"+992000000001",
+	phone:		"+992900000001",
 	balance: 	10_000_00,
 	payments: 	[]struct{
 		amount		types.Money
@@ -63,7 +72,7 @@ func (s *testService) addAccount(data testAccount) (*types.Account, []*types.Pay
 func TestService_FindAccountByID_success(t *testing.T) {
 	svc := &Service{}
 
-	account, _ := svc.RegisterAccount("+992000000001")
+	account, _ := svc.RegisterAccount("+992900000001")
 
 	acc, e := svc.FindAccountByID(account.ID)
 
@@ -288,7 +297,7 @@ func TestService_ExportToFile_EmptyData(t *testing.T) {
 func TestService_ExportToFile(t *testing.T) {
 	svc := &Service{}
 
-	_, err := svc.RegisterAccount("+992000000000")
+	_, err := svc.RegisterAccount("+992900000000")
 	if err != nil {
 		t.Error(err)
 	}
@@ -322,7 +331,7 @@ func TestService_ImportToFile(t *testing.T) {
 
 	k := 0
 	for _, account := range svc.accounts {
-		if account.Phone == "+992000000000" {
+		if account.Phone == "+992900000000" {
 			k++
 		}
 	}
@@ -335,12 +344,15 @@ func TestService_ImportToFile(t *testing.T) {
 func TestSetice_Export(t *testing.T) {
 	svc := &Service{}
 
-	account, err := svc.RegisterAccount("+992000000000")
+	account, err := svc.RegisterAccount("+992900000000")
 	if err != nil {
 		t.Error(err)
 	}
 
-	account.Balance = 100
+	err = svc.Deposit(account.ID, 100)
+	if err != nil {
+		t.Error(err)
+	}
 
 	payment, err := svc.Pay(account.ID, 100, "auto")
 	if err != nil {
@@ -381,19 +393,22 @@ func TestService_Import(t *testing.T) {
 		t.Error(err)
 	}
 
-	if svc.accounts[0].Phone != "+992000000000" {
+	if svc.accounts[0].Phone != "+992900000000" {
 		t.Error("incorrect func")
 	}
 }
 func TestService_Import_IfHaveData(t *testing.T) {
 	svc := &Service{}
 
-	account, err := svc.RegisterAccount("+992000000000")
+	account, err := svc.RegisterAccount("+992900000000")
 	if err != nil {
 		t.Error(err)
 	}
 
-	account.Balance = 100
+	err = svc.Deposit(account.ID, 100)
+	if err != nil {
+		t.Error(err)
+	}
 
 	payment, err := svc.Pay(account.ID, 100, "auto")
 	if err != nil {
@@ -574,7 +589,7 @@ func Benchmark_SumPayments(b *testing.B) {
 func Benchmark_FilterPayments(b *testing.B) {
 	svc := &Service{}
 
-	account, err := svc.RegisterAccount("+992000000000")
+	account, err := svc.RegisterAccount("+992900000000")
 	if err != nil {
 		b.Error(err)
 	}
@@ -594,4 +609,2077 @@ func Benchmark_FilterPayments(b *testing.B) {
 			b.Fatalf("invalid result, got %v, want %v", len(payments), result)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestService_Statement(t *testing.T) {
+	svc := &Service{}
+	current := time.Date(2021, time.March, 31, 12, 0, 0, 0, time.UTC)
+	svc.clock = func() time.Time { return current }
+
+	account, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(account.ID, 1_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current = time.Date(2021, time.April, 2, 12, 0, 0, 0, time.UTC)
+	err = svc.Deposit(account.ID, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := svc.Pay(account.ID, 300, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.Pay(account.ID, 200, "cafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current = time.Date(2021, time.May, 1, 12, 0, 0, 0, time.UTC)
+	_, err = svc.Pay(account.ID, 100, "cafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := svc.MonthlyStatement(account.ID, 2021, time.April)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statement.Opening != 1_000 || statement.Closing != 1_300 {
+		t.Errorf("Statement(): wrong balances, opening = %v, closing = %v", statement.Opening, statement.Closing)
+	}
+
+	if statement.Deposits != 500 || statement.Payments != 500 || statement.Refunds != 300 {
+		t.Errorf("Statement(): wrong totals, statement = %v", statement)
+	}
+
+	if len(statement.Lines) != 4 || statement.Lines[3].Balance != statement.Closing {
+		t.Errorf("Statement(): wrong lines, lines = %v", statement.Lines)
+	}
+
+	writers := map[string]func(io.Writer, *types.Statement) error{
+		"13.00 TJS":      WriteStatementText,
+		",1300":          WriteStatementCSV,
+		"13.00 TJS</td>": WriteStatementHTML,
+	}
+	for closing, write := range writers {
+		buf := &bytes.Buffer{}
+		err = write(buf, statement)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !strings.Contains(buf.String(), closing) {
+			t.Errorf("Statement(): closing balance not rendered, got %v", buf.String())
+		}
+	}
+}
+
+func TestService_DepositFrom(t *testing.T) {
+	svc := &Service{}
+
+	account, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, err := svc.DepositFrom(account.ID, 1_000, "card")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Posting{
+		{Account: WalletLedger(account.ID), Amount: 1_000},
+		{Account: DepositsLedger("card"), Amount: -1_000},
+	}
+	if transaction.Source != "card" || transaction.Type != types.EntryTypeDeposit || !reflect.DeepEqual(transaction.Postings, want) {
+		t.Errorf("DepositFrom(): wrong transaction = %v", transaction)
+	}
+
+	_, err = svc.Pay(account.ID, 300, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.CheckLedger()
+	if err != nil {
+		t.Error(err)
+	}
+
+	account.Balance = 5
+	err = svc.CheckLedger()
+	if !errors.Is(err, ErrBalanceMismatch) {
+		t.Errorf("CheckLedger(): must return ErrBalanceMismatch, returned = %v", err)
+	}
+}
+
+func TestService_reservedCharacters(t *testing.T) {
+	svc := &Service{}
+
+	account, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.DepositFrom(account.ID, 1_000, "card;1")
+	if !errors.Is(err, ErrReservedCharacter) {
+		t.Errorf("DepositFrom(): must return ErrReservedCharacter, returned = %v", err)
+	}
+
+	err = svc.Deposit(account.ID, 1_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, category := range []types.PaymentCategory{"auto,food", "a=b", "auto\n"} {
+		_, err = svc.Pay(account.ID, 100, category)
+		if !errors.Is(err, ErrReservedCharacter) {
+			t.Errorf("Pay(%q): must return ErrReservedCharacter, returned = %v", category, err)
+		}
+	}
+
+	if len(svc.payments) != 0 {
+		t.Errorf("Pay(): rejected payments must not be added, payments = %v", svc.payments)
+	}
+}
+
+func TestService_ExportImport_entries(t *testing.T) {
+	dir := t.TempDir()
+	svc := &Service{}
+
+	account, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.DepositFrom(account.ID, 1_000, "card")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.Pay(account.ID, 300, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := imported.AccountEntries(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].Source != "card" {
+		t.Errorf("Import(): wrong entries = %v", entries)
+	}
+
+	err = imported.CheckLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Import_withoutEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	err := ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992900000001;700\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &Service{}
+	err = svc.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.CheckLedger()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(svc.journal) != 1 || svc.journal[0].Type != types.EntryTypeOpening {
+		t.Errorf("Import(): opening transaction must be created, journal = %v", svc.journal)
+	}
+}
+
+func TestService_ImportRaw(t *testing.T) {
+	dir := t.TempDir()
+
+	svc := &Service{}
+	account, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992900000001;500\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := &Service{}
+	err = raw.ImportRaw(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(raw.journal) != 1 {
+		t.Errorf("ImportRaw(): opening transaction must not be created, journal = %v", raw.journal)
+	}
+
+	discrepancies := raw.Reconcile().Discrepancies
+	if len(discrepancies) != 1 || discrepancies[0].Kind != types.DiscrepancyBalanceMismatch {
+		t.Errorf("Reconcile(): must report balance 500 against journal 100, discrepancies = %v", discrepancies)
+	}
+}
+
+func TestService_ImportRaw_duplicateIDs(t *testing.T) {
+	dir := t.TempDir()
+
+	dumps := map[string]string{
+		"accounts.dump": "1;+992900000001;0\n1;+992900000002;0\n",
+		"payments.dump": "p1;1;0;auto;INPROGRESS;0\np1;1;0;auto;OK;0\n",
+	}
+	for name, data := range dumps {
+		err := ioutil.WriteFile(dir+"/"+name, []byte(data), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Service{}
+	err := s.ImportRaw(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.accounts) != 2 || len(s.payments) != 2 {
+		t.Fatalf("ImportRaw(): every row must be kept, accounts = %v, payments = %v", s.accounts, s.payments)
+	}
+
+	found := make(map[string]bool)
+	for _, discrepancy := range s.Reconcile().Discrepancies {
+		if discrepancy.Kind == types.DiscrepancyDuplicateID {
+			found[discrepancy.Entity+" "+discrepancy.ID] = true
+		}
+	}
+	if !found["account 1"] || !found["payment p1"] {
+		t.Errorf("Reconcile(): must report duplicate account 1 and payment p1, found = %v", found)
+	}
+
+	s = &Service{}
+	err = s.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.accounts) != 1 || s.accounts[0].Phone != "+992900000002" || len(s.payments) != 1 {
+		t.Errorf("Import(): last row must win, accounts = %v, payments = %v", s.accounts, s.payments)
+	}
+}
+
+func TestService_TrialBalance(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trialBalance, err := s.TrialBalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trialBalance.Debit != trialBalance.Credit {
+		t.Errorf("TrialBalance(): debit %v != credit %v", trialBalance.Debit, trialBalance.Credit)
+	}
+
+	want := map[types.LedgerAccount]types.Money{
+		WalletLedger(1):                      defaultTestAccount.balance,
+		DepositsLedger(DefaultDepositSource): -defaultTestAccount.balance,
+		CategoryLedger("auto"):               1_000_00,
+		RefundsLedger:                        -1_000_00,
+	}
+	for _, line := range trialBalance.Lines {
+		if line.Debit-line.Credit != want[line.Account] {
+			t.Errorf("TrialBalance(): wrong line = %v", line)
+		}
+	}
+
+	err = s.CheckLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Reconcile(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := s.Reconcile()
+	if len(report.Discrepancies) != 0 {
+		t.Errorf("Reconcile(): must be clean, discrepancies = %v", report.Discrepancies)
+	}
+
+	s.accounts = append(s.accounts, &types.Account{ID: 7, Phone: account.Phone, Balance: -5})
+	s.payments = append(s.payments, &types.Payment{ID: payments[0].ID, AccountID: 42, Amount: 1})
+	s.favorites = append(s.favorites, &types.Favorite{ID: "f", AccountID: 42})
+
+	kinds := make(map[types.DiscrepancyKind]int)
+	for _, discrepancy := range s.Reconcile().Discrepancies {
+		kinds[discrepancy.Kind]++
+	}
+
+	want := map[types.DiscrepancyKind]int{
+		types.DiscrepancyDuplicatePhone:  1,
+		types.DiscrepancyNegativeBalance: 1,
+		types.DiscrepancyDuplicateID:     1,
+		types.DiscrepancyOrphanPayment:   1,
+		types.DiscrepancyOrphanFavorite:  1,
+		types.DiscrepancyBalanceMismatch: 1,
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("Reconcile(): got %v, want %v", kinds, want)
+	}
+}
+
+func TestService_AuditLog(t *testing.T) {
+	path := t.TempDir() + "/audit.log"
+
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService()
+	s.SetAuditLog(auditLog)
+	s.SetActor("operator")
+
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(1, 1_000_000_00, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Fatalf("Pay(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+
+	head := auditLog.Head()
+	err = auditLog.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != head {
+		t.Errorf("VerifyAuditLog(): head = %v, want %v", got, head)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], `"actor":"operator"`) || !strings.Contains(lines[3], "error: ") {
+		t.Fatalf("audit log has wrong records = %v", lines)
+	}
+
+	changed := strings.Join(lines, "\n")
+	changed = strings.Replace(changed, `"operation":"reject"`, `"operation":"repeat"`, 1)
+	err = ioutil.WriteFile(path, []byte(changed+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = VerifyAuditLog(path)
+	if !errors.Is(err, ErrAuditLogBroken) {
+		t.Errorf("VerifyAuditLog(): changed record must be found, error = %v", err)
+	}
+
+	removed := strings.Join(append(lines[:1], lines[2:]...), "\n")
+	err = ioutil.WriteFile(path, []byte(removed+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenAuditLog(path)
+	if !errors.Is(err, ErrAuditLogBroken) {
+		t.Errorf("OpenAuditLog(): removed record must be found, error = %v", err)
+	}
+
+	truncated := strings.Join(lines[:3], "\n")
+	err = ioutil.WriteFile(path, []byte(truncated+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = VerifyAuditLog(path)
+	if !errors.Is(err, ErrAuditLogBroken) {
+		t.Errorf("VerifyAuditLog(): record removed from the end must be found, error = %v", err)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenAuditLog(path)
+	if !errors.Is(err, ErrAuditLogBroken) {
+		t.Errorf("OpenAuditLog(): removed log must be found, error = %v", err)
+	}
+}
+
+func TestService_Subscribe(t *testing.T) {
+	s := newTestService()
+
+	all := s.Subscribe(10, BackpressureBlock)
+	payments := s.Subscribe(10, BackpressureBlock, types.EventPaymentCreated, types.EventPaymentRejected)
+	newest := s.Subscribe(1, BackpressureDropNewest)
+	oldest := s.Subscribe(1, BackpressureDropOldest)
+
+	_, created, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Reject(created[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all.Unsubscribe()
+	payments.Unsubscribe()
+	newest.Unsubscribe()
+	oldest.Unsubscribe()
+	all.Unsubscribe()
+
+	got := []types.EventType{}
+	for event := range all.C {
+		got = append(got, event.Type)
+	}
+
+	want := []types.EventType{types.EventAccountRegistered, types.EventDeposit, types.EventPaymentCreated, types.EventPaymentRejected}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscribe(): got %v, want %v", got, want)
+	}
+
+	got = []types.EventType{}
+	for event := range payments.C {
+		got = append(got, event.Type)
+		if event.Payment == nil || event.Payment.ID != created[0].ID {
+			t.Errorf("Subscribe(): wrong payment in event = %v", event)
+		}
+		if event.Amount != defaultTestAccount.payments[0].amount || event.Payment.Amount != event.Amount {
+			t.Errorf("Subscribe(): event must have amount of payment, event = %v", event)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want[2:]) {
+		t.Errorf("Subscribe(): got %v, want %v", got, want[2:])
+	}
+
+	event := <-newest.C
+	if event.Type != types.EventAccountRegistered || newest.Dropped() != 3 {
+		t.Errorf("Subscribe(): drop newest got %v, dropped %v", event.Type, newest.Dropped())
+	}
+
+	event = <-oldest.C
+	if event.Type != types.EventPaymentRejected || oldest.Dropped() != 3 {
+		t.Errorf("Subscribe(): drop oldest got %v, dropped %v", event.Type, oldest.Dropped())
+	}
+}
+
+func TestService_Subscribe_blockedUnsubscribe(t *testing.T) {
+	s := newTestService()
+	subscription := s.Subscribe(0, BackpressureBlock)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := s.RegisterAccount("+992900000001")
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	subscription.Unsubscribe()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Unsubscribe(): blocked publisher must be released")
+	}
+}
+
+func TestService_FindAccountByPhone(t *testing.T) {
+	s := newTestService()
+	account, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.FindAccountByPhone(defaultTestAccount.phone)
+	if err != nil || got != account {
+		t.Errorf("FindAccountByPhone(): got %v, error = %v", got, err)
+	}
+
+	_, err = s.FindAccountByPhone("+992900000099")
+	if err != ErrAccountNotFound {
+		t.Errorf("FindAccountByPhone(): must return ErrAccountNotFound, returned = %v", err)
+	}
+}
+
+func TestService_QueryPayments(t *testing.T) {
+	svc := &Service{}
+	current := time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC)
+	svc.clock = func() time.Time {
+		current = current.Add(time.Hour)
+		return current
+	}
+
+	first, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := svc.RegisterAccount("+992900000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, account := range []*types.Account{first, second} {
+		err = svc.Deposit(account.ID, 1_000)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	amounts := []types.Money{50, 10, 30, 10, 20}
+	for i, amount := range amounts {
+		category := types.PaymentCategory("cafe")
+		if i%2 == 1 {
+			category = "auto"
+		}
+
+		_, err = svc.Pay(first.ID, amount, category)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = svc.Pay(second.ID, 40, "cafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := types.PaymentQuery{AccountID: first.ID, SortBy: types.SortByAmount, Limit: 2}
+	got := []types.Money{}
+	pages := 0
+	for {
+		page, err := svc.QueryPayments(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pages++
+		for _, payment := range page.Payments {
+			got = append(got, payment.Amount)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+
+		// new payment doesn't change pages which are already returned
+		_, err = svc.Pay(second.ID, 5, "cafe")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pages != 3 || !reflect.DeepEqual(got, []types.Money{10, 10, 20, 30, 50}) {
+		t.Errorf("QueryPayments(): pages = %v, amounts = %v", pages, got)
+	}
+
+	firstPage, err := svc.QueryPayments(types.PaymentQuery{AccountID: first.ID, SortBy: types.SortByAmount, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, other := range []types.PaymentQuery{
+		{SortBy: types.SortByTime, Cursor: firstPage.NextCursor},
+		{SortBy: types.SortByAmount, Desc: true, Cursor: firstPage.NextCursor},
+	} {
+		_, err = svc.QueryPayments(other)
+		if err != ErrBadCursor {
+			t.Errorf("QueryPayments(%v): cursor of other sort must return ErrBadCursor, returned = %v", other, err)
+		}
+	}
+
+	page, err := svc.QueryPayments(types.PaymentQuery{
+		Categories: []types.PaymentCategory{"cafe"},
+		MinAmount:  30,
+		Desc:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Payments) != 3 || page.Payments[0].AccountID != second.ID || page.Payments[2].Amount != 50 {
+		t.Errorf("QueryPayments(): wrong page = %v", page.Payments)
+	}
+
+	from := page.Payments[1].Time
+	page, err = svc.QueryPayments(types.PaymentQuery{From: from, To: from + 1, IDPrefix: page.Payments[1].ID[:8]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Payments) != 1 || page.Payments[0].Amount != 30 {
+		t.Errorf("QueryPayments(): wrong page = %v", page.Payments)
+	}
+
+	_, err = svc.QueryPayments(types.PaymentQuery{Cursor: "???"})
+	if err != ErrBadCursor {
+		t.Errorf("QueryPayments(): must return ErrBadCursor, returned = %v", err)
+	}
+}
+
+func TestParsePredicate(t *testing.T) {
+	payments := []types.Payment{
+		{ID: "a1", AccountID: 1, Amount: 50, Category: "Cafe", Status: types.PaymentStatusOk, Time: 10},
+		{ID: "a2", AccountID: 1, Amount: 150, Category: "Cafe", Status: types.PaymentStatusFail, Time: 20},
+		{ID: "b1", AccountID: 2, Amount: 200, Category: "auto", Status: types.PaymentStatusOk, Time: 30},
+		{ID: "b2", AccountID: 2, Amount: 100, Category: "mobile phone", Status: types.PaymentStatusInProgress, Time: 40},
+		{ID: "b3", AccountID: 2, Amount: 120, Category: "Ресторан", Status: types.PaymentStatusOk, Time: 50},
+	}
+
+	tests := []struct {
+		expression string
+		want       []string
+	}{
+		{`category=Cafe and amount>1.00`, []string{"a2"}},
+		{`category = Cafe or amount >= 2TJS`, []string{"a1", "a2", "b1"}},
+		{`not (account=1 or status=OK)`, []string{"b2"}},
+		{`category="mobile phone"`, []string{"b2"}},
+		{`account!=1 AND time<40`, []string{"b1"}},
+		{`id=a1 or id=b1 and status!=OK`, []string{"a1"}},
+		{`amount<="1.00 TJS" and not category=Cafe`, []string{"b2"}},
+		// second byte of Р is 0xA0, it isn't space
+		{`category=Ресторан and amount>1.0`, []string{"b3"}},
+	}
+
+	for _, test := range tests {
+		predicate, err := ParsePredicate(test.expression)
+		if err != nil {
+			t.Errorf("ParsePredicate(%q): error = %v", test.expression, err)
+			continue
+		}
+
+		got := []string{}
+		for _, payment := range payments {
+			if predicate(payment) {
+				got = append(got, payment.ID)
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParsePredicate(%q): got %v, want %v", test.expression, got, test.want)
+		}
+	}
+
+	ru, err := FindLocale("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	predicate, err := ParsePredicateLocale(`amount>=1,20`, ru)
+	if err != nil || !predicate(payments[4]) || predicate(payments[3]) {
+		t.Errorf("ParsePredicateLocale(): amount must be read in locale, error = %v", err)
+	}
+
+	// amount>100 could be 1.00 or 100.00
+	for _, expression := range []string{``, `category`, `category>Cafe`, `amount=ten`, `phone=1`, `(amount>1.00`, `amount>1.00 amount<2.00`,
+		`category="Cafe`, `amount>100`, `amount>1USD`} {
+		_, err := ParsePredicate(expression)
+		if !errors.Is(err, ErrBadExpression) {
+			t.Errorf("ParsePredicate(%q): must return ErrBadExpression, returned = %v", expression, err)
+		}
+	}
+}
+
+func TestService_FilterPaymentsByFn_predicates(t *testing.T) {
+	s := newTestService()
+	account, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, category := range []types.PaymentCategory{"cafe", "auto", "cafe"} {
+		_, err = s.Pay(account.ID, 1_00, category)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payments, err := s.FilterPaymentsByFn(And(ByAccount(account.ID), Or(ByCategory("cafe"), AmountBetween(1_000_00, 2_000_00)), Not(ByStatus(types.PaymentStatusFail))), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(payments) != 3 {
+		t.Errorf("FilterPaymentsByFn(): got %v", payments)
+	}
+}
+
+func TestService_Context_cancelled(t *testing.T) {
+	s := newTestService()
+	account, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(dir + "/payments.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 1_00, "cafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.SumPaymentsContext(ctx, 2)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SumPaymentsContext(): error = %v", err)
+	}
+
+	_, err = s.FilterPaymentsContext(ctx, account.ID, 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("FilterPaymentsContext(): error = %v", err)
+	}
+
+	_, err = s.FilterPaymentsByFnContext(ctx, ByAccount(account.ID), 3)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("FilterPaymentsByFnContext(): error = %v", err)
+	}
+
+	for progress := range s.SumPaymentsWithProgressContext(ctx, 1) {
+		t.Errorf("SumPaymentsWithProgressContext(): got %v", progress)
+	}
+
+	err = s.ExportContext(ctx, dir)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExportContext(): error = %v", err)
+	}
+
+	after, err := ioutil.ReadFile(dir + "/payments.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Errorf("ExportContext(): payments.dump changed to %q", after)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			t.Errorf("ExportContext(): temporary file %s left", file.Name())
+		}
+	}
+
+	err = (&Service{}).ImportContext(ctx, dir)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ImportContext(): error = %v", err)
+	}
+
+	sum, err := s.SumPaymentsContext(context.Background(), 2)
+	if err != nil || sum != 1_001_00 {
+		t.Errorf("SumPaymentsContext(): sum = %v, error = %v", sum, err)
+	}
+}
+
+func TestService_ScanPayments(t *testing.T) {
+	svc := &Service{}
+	for i := 0; i < 2_500; i++ {
+		svc.payments = append(svc.payments, &types.Payment{ID: fmt.Sprint(i), AccountID: int64(i % 3), Amount: types.Money(i)})
+	}
+	for i := 0; i < 5; i++ {
+		_, err := svc.RegisterAccount(types.Phone(fmt.Sprintf("+99290000000%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, goroutines := range []int{-1, 0, 1, 2, 3, 7, 5_000} {
+		sum := svc.SumPayments(goroutines)
+		if sum != 2_500*2_499/2 {
+			t.Errorf("SumPayments(%d): got %v", goroutines, sum)
+		}
+
+		payments, err := svc.FilterPaymentsByFn(func(payment types.Payment) bool { return payment.Amount%2 == 0 }, goroutines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payments) != 1_250 {
+			t.Fatalf("FilterPaymentsByFn(%d): got %v payments", goroutines, len(payments))
+		}
+		for i, payment := range payments {
+			if payment.Amount != types.Money(2*i) {
+				t.Fatalf("FilterPaymentsByFn(%d): payment %d is %v", goroutines, i, payment)
+			}
+		}
+
+		payments, err = svc.FilterPayments(1, goroutines)
+		if err != nil || len(payments) != 833 {
+			t.Errorf("FilterPayments(%d): got %v payments, error = %v", goroutines, len(payments), err)
+		}
+
+		phones, err := svc.ScanAccounts(context.Background(), goroutines, func(accounts []types.Account) interface{} {
+			phones := ""
+			for _, account := range accounts {
+				phones += string(account.Phone[len(account.Phone)-1:])
+			}
+			return phones
+		}, func(accumulated interface{}, partial interface{}) interface{} {
+			return accumulated.(string) + partial.(string)
+		}, "")
+		if err != nil || phones != "01234" {
+			t.Errorf("ScanAccounts(%d): got %v, error = %v", goroutines, phones, err)
+		}
+	}
+
+	empty := &Service{}
+	if empty.SumPayments(0) != 0 {
+		t.Error("SumPayments(): must be 0 without payments")
+	}
+
+	_, err := empty.FilterPayments(1, 0)
+	if err != ErrAccountNotFound {
+		t.Errorf("FilterPayments(): error = %v", err)
+	}
+}
+
+func TestService_SumPaymentsWithProgress(t *testing.T) {
+	svc := &Service{}
+	for i := 0; i < 1_234; i++ {
+		svc.payments = append(svc.payments, &types.Payment{Amount: types.Money(i)})
+	}
+
+	want := svc.SumPayments(4)
+	for _, chunkSize := range []int{0, 1, 100, 1_234, 5_000} {
+		parts, processed, sum, last := 0, 0, types.Money(0), types.Progress{}
+		for progress := range svc.SumPaymentsWithProgressContext(context.Background(), chunkSize) {
+			if last.Done {
+				t.Fatalf("chunk %d: progress %v after done", chunkSize, progress)
+			}
+			parts++
+			processed += progress.Part
+			sum += progress.Result
+			if progress.Processed != processed || progress.Sum != sum || progress.Total != 1_234 {
+				t.Fatalf("chunk %d: got %v, processed %v, sum %v", chunkSize, progress, processed, sum)
+			}
+			last = progress
+		}
+
+		if sum != want || !last.Done || last.Sum != want || last.Percent != 100 {
+			t.Errorf("chunk %d: sum = %v, last = %v, want %v", chunkSize, sum, last, want)
+		}
+
+		if chunkSize == 100 && parts != 13 {
+			t.Errorf("chunk %d: got %v parts", chunkSize, parts)
+		}
+	}
+
+	progress := <-svc.SumPaymentsWithProgress()
+	if !progress.Done || progress.Sum != want || progress.Part != 1_234 {
+		t.Errorf("SumPaymentsWithProgress(): got %v", progress)
+	}
+
+	progress, ok := <-(&Service{}).SumPaymentsWithProgress()
+	if !ok || !progress.Done || progress.Sum != 0 || progress.Percent != 100 {
+		t.Errorf("SumPaymentsWithProgress(): without payments got %v", progress)
+	}
+}
+
+func TestService_AggregatePayments(t *testing.T) {
+	svc := &Service{}
+	for i := 1; i <= 100; i++ {
+		svc.payments = append(svc.payments, &types.Payment{AccountID: int64(i%2 + 9), Amount: types.Money(i), Category: "cafe", Status: types.PaymentStatusOk})
+	}
+	svc.payments = append(svc.payments, &types.Payment{AccountID: 10, Amount: 1_000, Category: "auto", Status: types.PaymentStatusOk})
+	svc.payments = append(svc.payments, &types.Payment{AccountID: 10, Amount: 0, Category: "cafe", Status: types.PaymentStatusFail})
+
+	report, err := svc.AggregatePayments(types.GroupByCategory, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.AggregateGroup{
+		{Key: "auto", Count: 1, Total: 1_000, Average: 1_000, Min: 1_000, Max: 1_000, P50: 1_000, P90: 1_000, P99: 1_000},
+		{Key: "cafe", Count: 100, Total: 5_050, Average: 50, Min: 1, Max: 100, P50: 50, P90: 90, P99: 99},
+	}
+	if !reflect.DeepEqual(report.Groups, want) || report.Count != 101 || report.Total != 6_050 {
+		t.Errorf("AggregatePayments(): got %v", report)
+	}
+
+	report, err = svc.AggregatePaymentsContext(context.Background(), types.GroupByAccount, ByStatus(types.PaymentStatusOk), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Groups) != 2 || report.Groups[0].Key != "9" || report.Groups[0].Total != 2_550 || report.Groups[1].Total != 3_500 {
+		t.Errorf("AggregatePaymentsContext(): got %v", report)
+	}
+
+	buf := &bytes.Buffer{}
+	err = WriteAggregateCSV(buf, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "account,count,total,average,min,max,p50,p90,p99\n9,50,2550,51,2,100,50,90,100\n") {
+		t.Errorf("WriteAggregateCSV(): got %q", buf.String())
+	}
+
+	report, err = svc.AggregatePayments(types.GroupByStatus, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rejected payment is skipped by other groupings, but it has its own status
+	want = []types.AggregateGroup{
+		{Key: string(types.PaymentStatusFail), Count: 1},
+		{Key: string(types.PaymentStatusOk), Count: 101, Total: 6_050, Average: 59, Min: 1, Max: 1_000, P50: 51, P90: 91, P99: 100},
+	}
+	if !reflect.DeepEqual(report.Groups, want) || report.Count != 102 || report.Total != 6_050 {
+		t.Errorf("AggregatePayments(): by status got %v", report)
+	}
+
+	_, err = svc.AggregatePayments("phone", 1)
+	if !errors.Is(err, ErrBadQuery) {
+		t.Errorf("AggregatePayments(): error = %v", err)
+	}
+}
+
+func TestService_Top(t *testing.T) {
+	svc := &Service{}
+	categories := []types.PaymentCategory{"auto", "cafe", "mobile", "rent"}
+	for i := 0; i < 3_000; i++ {
+		svc.payments = append(svc.payments, &types.Payment{
+			ID:        fmt.Sprint(i),
+			AccountID: int64(i%7 + 1),
+			Amount:    types.Money(i * 37 % 1_000),
+			Category:  categories[i%len(categories)],
+		})
+	}
+
+	sorted := make([]types.Payment, 0, len(svc.payments))
+	totals := map[int64]types.Money{}
+	for _, payment := range svc.payments {
+		sorted = append(sorted, *payment)
+		totals[payment.AccountID] += payment.Amount
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Amount > sorted[j].Amount })
+
+	for _, goroutines := range []int{0, 1, 4} {
+		payments, err := svc.TopPayments(5, goroutines)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(payments, sorted[:5]) {
+			t.Errorf("TopPayments(%d): got %v, want %v", goroutines, payments, sorted[:5])
+		}
+
+		accounts, err := svc.TopAccounts(10, goroutines)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(accounts) != 7 {
+			t.Fatalf("TopAccounts(%d): got %v", goroutines, accounts)
+		}
+		for i, entry := range accounts {
+			id, _ := strconv.ParseInt(entry.Key, 10, 64)
+			if entry.Total != totals[id] || (i > 0 && entry.Total > accounts[i-1].Total) {
+				t.Errorf("TopAccounts(%d): got %v", goroutines, accounts)
+			}
+		}
+	}
+
+	svc.payments = []*types.Payment{
+		{ID: "a", AccountID: 2, Amount: 100, Category: "cafe"},
+		{ID: "b", AccountID: 1, Amount: 100, Category: "auto"},
+		// rejected payment of old dump which kept amount
+		{ID: "c", AccountID: 3, Amount: 500, Category: "cafe", Status: types.PaymentStatusFail},
+	}
+
+	// rejected payments are skipped, categories with equal totals go by name
+	categoriesTop, err := svc.TopCategories(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(categoriesTop, []types.TopEntry{{Key: "auto", Total: 100, Count: 1}}) {
+		t.Errorf("TopCategories(): got %v", categoriesTop)
+	}
+
+	categoriesTop, err = svc.TopCategoriesContext(context.Background(), 2, Not(ByStatus(types.PaymentStatusFail)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(categoriesTop, []types.TopEntry{{Key: "auto", Total: 100, Count: 1}, {Key: "cafe", Total: 100, Count: 1}}) {
+		t.Errorf("TopCategoriesContext(): got %v", categoriesTop)
+	}
+
+	accounts, err := svc.TopAccounts(2, 1)
+	if err != nil || len(accounts) != 2 || accounts[0].Key != "1" || accounts[1].Key != "2" {
+		t.Errorf("TopAccounts(): got %v, error = %v", accounts, err)
+	}
+
+	payments, err := svc.TopPayments(1, 1)
+	if err != nil || len(payments) != 1 || payments[0].ID != "a" {
+		t.Errorf("TopPayments(): got %v, error = %v", payments, err)
+	}
+
+	for _, n := range []int{0, MaxTopSize + 1} {
+		_, err = svc.TopPayments(n, 1)
+		if !errors.Is(err, ErrBadQuery) {
+			t.Errorf("TopPayments(%d): error = %v", n, err)
+		}
+	}
+}
+
+func TestService_SpendingSeries(t *testing.T) {
+	svc := &Service{}
+	day := func(month time.Month, day int) int64 {
+		return time.Date(2026, month, day, 12, 0, 0, 0, time.UTC).Unix()
+	}
+	svc.payments = []*types.Payment{
+		{ID: "1", AccountID: 1, Amount: 100, Category: "cafe", Time: day(time.March, 2)},
+		{ID: "2", AccountID: 2, Amount: 200, Category: "auto", Time: day(time.March, 4)},
+		{ID: "3", AccountID: 1, Amount: 300, Category: "cafe", Time: day(time.March, 4)},
+		{ID: "4", AccountID: 1, Amount: 400, Category: "cafe", Time: day(time.May, 31)},
+		{ID: "5", AccountID: 1, Amount: 500, Category: "cafe"},
+	}
+
+	report, err := svc.SpendingSeries(types.PeriodMonth, "", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Series{{Key: TotalSeriesKey, Points: []types.SeriesPoint{
+		{Start: time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC).Unix(), Total: 600, Count: 3},
+		{Start: time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		{Start: time.Date(2026, time.May, 1, 0, 0, 0, 0, time.UTC).Unix(), Total: 400, Count: 1},
+	}}}
+	if !reflect.DeepEqual(report.Series, want) {
+		t.Errorf("SpendingSeries(): got %v, want %v", report.Series, want)
+	}
+
+	// payment is in the first week bucket, but before from
+	svc.payments = append(svc.payments, &types.Payment{ID: "6", AccountID: 1, Amount: 600, Category: "cafe", Time: day(time.February, 28)})
+
+	from := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	report, err = svc.SpendingSeriesContext(context.Background(), types.PeriodWeek, types.GroupByCategory, from, from.AddDate(0, 0, 14), nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Series) != 2 || report.Series[0].Key != "auto" || report.Series[1].Key != "cafe" {
+		t.Fatalf("SpendingSeriesContext(): got %v", report.Series)
+	}
+
+	// 1 March of 2026 is Sunday, so its week starts on 23 February
+	cafe := report.Series[1].Points
+	if len(cafe) != 3 || cafe[0].Total != 0 || cafe[1].Start != time.Date(2026, time.March, 2, 0, 0, 0, 0, time.UTC).Unix() || cafe[1].Total != 400 || cafe[2].Count != 0 {
+		t.Errorf("SpendingSeriesContext(): cafe = %v", cafe)
+	}
+
+	buf := &bytes.Buffer{}
+	err = WriteSeriesChart(buf, report, DefaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "cafe by week\n2026-02-23 |"+strings.Repeat(" ", 40)+" 0.00 TJS\n2026-03-02 |"+strings.Repeat("#", 40)+" 4.00 TJS\n") {
+		t.Errorf("WriteSeriesChart(): got %q", buf.String())
+	}
+
+	_, err = svc.SpendingSeries("year", "", time.Time{}, time.Time{})
+	if !errors.Is(err, ErrBadQuery) {
+		t.Errorf("SpendingSeries(): error = %v", err)
+	}
+
+	_, err = svc.SpendingSeries(types.PeriodDay, "", time.Unix(0, 0), from)
+	if !errors.Is(err, ErrBadQuery) {
+		t.Errorf("SpendingSeries(): error = %v", err)
+	}
+
+	report, err = (&Service{}).SpendingSeries(types.PeriodDay, types.GroupByAccount, time.Time{}, time.Time{})
+	if err != nil || len(report.Series) != 0 {
+		t.Errorf("SpendingSeries(): got %v, error = %v", report, err)
+	}
+}
+
+func TestService_AccountStatus(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Status != types.AccountStatusActive {
+		t.Errorf("RegisterAccount(): status = %v", account.Status)
+	}
+
+	err = s.Freeze(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Deposit(account.ID, 1)
+	if err != ErrAccountFrozen {
+		t.Errorf("Deposit(): error = %v", err)
+	}
+
+	_, err = s.Pay(account.ID, 1, "auto")
+	if err != ErrAccountFrozen {
+		t.Errorf("Pay(): error = %v", err)
+	}
+
+	_, err = s.Repeat(payments[0].ID)
+	if err != ErrAccountFrozen {
+		t.Errorf("Repeat(): error = %v", err)
+	}
+
+	_, err = s.PayFromFavorite(favorite.ID)
+	if err != ErrAccountFrozen {
+		t.Errorf("PayFromFavorite(): error = %v", err)
+	}
+
+	err = s.Close(account.ID)
+	if err != ErrAccountHasBalance {
+		t.Errorf("Close(): error = %v", err)
+	}
+
+	err = s.Unfreeze(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, account.Balance, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Close(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Deposit(account.ID, 1)
+	if err != ErrAccountClosed {
+		t.Errorf("Deposit(): error = %v", err)
+	}
+
+	err = s.Reject(payments[0].ID)
+	if err != ErrAccountClosed {
+		t.Errorf("Reject(): error = %v", err)
+	}
+
+	for _, change := range []func(int64) error{s.Freeze, s.Unfreeze, s.Close} {
+		err = change(account.ID)
+		if err != ErrAccountClosed {
+			t.Errorf("change of closed account: error = %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := imported.FindAccountByID(account.ID)
+	if err != nil || got.Status != types.AccountStatusClosed {
+		t.Errorf("Import(): account = %v, error = %v", got, err)
+	}
+
+	err = ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992900000001;0;LOCKED\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = (&Service{}).Import(dir)
+	if !errors.Is(err, ErrWrongFormat) {
+		t.Errorf("Import(): error = %v", err)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone  types.Phone
+		want   types.Phone
+		reason string
+	}{
+		{phone: "+992988000011", want: "+992988000011"},
+		{phone: "992988000011", want: "+992988000011"},
+		{phone: "+992 98 800 00 11", want: "+992988000011"},
+		{phone: "00992 (98) 800-00-11", want: "+992988000011"},
+		{phone: "988000011", want: "+992988000011"},
+		{phone: "+992900000001", want: "+992900000001"},
+		{phone: "+7 916 123-45-67", want: "+79161234567"},
+		{phone: "+99298800001", reason: "must have 9 digits"},
+		{phone: "+992668000011", reason: "unknown operator 66"},
+		{phone: "+992000000001", reason: "unknown operator 00"},
+		{phone: "+992-98-abc", reason: "unexpected character"},
+		{phone: "+123", reason: "from 8 to 15 digits"},
+		{phone: " ", reason: "empty number"},
+	}
+
+	for _, test := range tests {
+		got, err := NormalizePhone(test.phone)
+		if test.reason == "" {
+			if err != nil || got != test.want {
+				t.Errorf("NormalizePhone(%q): got %q, error = %v", test.phone, got, err)
+			}
+			continue
+		}
+
+		phoneErr := &PhoneError{}
+		if !errors.As(err, &phoneErr) || !errors.Is(err, ErrInvalidPhone) || !strings.Contains(phoneErr.Reason, test.reason) {
+			t.Errorf("NormalizePhone(%q): error = %v, want reason %q", test.phone, err, test.reason)
+		}
+	}
+}
+
+func TestService_RegisterAccount_normalizesPhone(t *testing.T) {
+	svc := &Service{}
+	account, err := svc.RegisterAccount("+992 98 800 00 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Phone != "+992988000011" {
+		t.Errorf("RegisterAccount(): phone = %v", account.Phone)
+	}
+
+	_, err = svc.RegisterAccount("992988000011")
+	if err != ErrPhoneRegistered {
+		t.Errorf("RegisterAccount(): error = %v", err)
+	}
+
+	_, err = svc.RegisterAccount("+992 98")
+	if !errors.Is(err, ErrInvalidPhone) {
+		t.Errorf("RegisterAccount(): error = %v", err)
+	}
+
+	found, err := svc.FindAccountByPhone("0099298 800 00 11")
+	if err != nil || found != account {
+		t.Errorf("FindAccountByPhone(): got %v, error = %v", found, err)
+	}
+
+	dir := t.TempDir()
+	err = ioutil.WriteFile(dir+"/accounts.dump", []byte("1;992 98 800 00 22;0;ACTIVE\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = imported.FindAccountByPhone("+992988000022")
+	if err != nil {
+		t.Errorf("Import(): account isn't found by normalized phone, error = %v", err)
+	}
+}
+
+func TestService_Import_duplicatePhones(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992988000011;0;ACTIVE\n2;992 98 800 00 11;0;ACTIVE\n3;988000022;0;ACTIVE\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &Service{}
+	err = svc.Import(dir)
+	conflict := &PhoneConflictError{}
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrPhoneRegistered) ||
+		!reflect.DeepEqual(conflict.Conflicts, []PhoneConflict{{Phone: "+992988000011", AccountID: 2, OtherAccountID: 1}}) {
+		t.Fatalf("Import(): must return conflict of accounts 1 and 2, error = %v", err)
+	}
+
+	// duplicates are loaded, so they can be merged
+	if len(svc.accounts) != 3 || svc.accounts[1].Phone != "+992988000011" || svc.accounts[2].Phone != "+992988000022" {
+		t.Errorf("Import(): phones must be normalized, accounts = %v", svc.Accounts())
+	}
+
+	found, err := svc.FindAccountByPhone("992988000011")
+	if err != nil || found.ID != 1 {
+		t.Errorf("FindAccountByPhone(): got %v, error = %v", found, err)
+	}
+
+	discrepancies := svc.Reconcile().Discrepancies
+	if len(discrepancies) != 1 || discrepancies[0].Kind != types.DiscrepancyDuplicatePhone || discrepancies[0].ID != "2" {
+		t.Errorf("Reconcile(): must report duplicate phone, discrepancies = %v", discrepancies)
+	}
+
+	_, err = svc.RegisterAccount("+992 98 800 00 11")
+	if err != ErrPhoneRegistered {
+		t.Errorf("RegisterAccount(): error = %v", err)
+	}
+
+	err = ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992988000011;0;ACTIVE\n2;phone;0;ACTIVE\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = (&Service{}).Import(dir)
+	if !errors.Is(err, ErrWrongFormat) {
+		t.Errorf("Import(): invalid phone must not be loaded, error = %v", err)
+	}
+}
+
+func TestService_PayToPhone(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	svc := &Service{clock: func() time.Time { return now }}
+	svc.SetClaimTTL(time.Hour)
+
+	sender, err := svc.RegisterAccount("+992988000011")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipient, err := svc.RegisterAccount("+992988000022")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(sender.ID, 1_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := svc.PayToPhone(sender.ID, "98 800 00 22", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transfer.Status != types.TransferStatusCompleted || transfer.ToAccountID != recipient.ID || recipient.Balance != 100 || sender.Balance != 900 {
+		t.Errorf("PayToPhone(): transfer = %v, sender = %v, recipient = %v", transfer, sender, recipient)
+	}
+
+	_, err = svc.PayToPhone(sender.ID, sender.Phone, 100)
+	if err != ErrTransferToSelf {
+		t.Errorf("PayToPhone(): error = %v", err)
+	}
+
+	_, err = svc.PayToPhone(sender.ID, "+992988000033", 1_000)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("PayToPhone(): error = %v", err)
+	}
+
+	claimed, err := svc.PayToPhone(sender.ID, "+992988000033", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := svc.PayToPhone(sender.ID, "+992988000044", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claimed.Status != types.TransferStatusPending || sender.Balance != 400 || svc.balances[ClaimsLedger("+992988000033")] != 200 {
+		t.Errorf("PayToPhone(): transfer = %v, sender = %v", claimed, sender)
+	}
+
+	err = svc.Close(sender.ID)
+	if !errors.Is(err, ErrAccountHasBalance) {
+		t.Errorf("Close(): error = %v", err)
+	}
+
+	dir := t.TempDir()
+	err = svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	third, err := svc.RegisterAccount("992 98 800 00 33")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third.Balance != 200 || claimed.Status != types.TransferStatusCompleted || claimed.ToAccountID != third.ID {
+		t.Errorf("RegisterAccount(): account = %v, transfer = %v", third, claimed)
+	}
+
+	now = now.Add(time.Hour)
+	count, err := svc.ExpireClaims()
+	if err != nil || count != 1 {
+		t.Errorf("ExpireClaims(): count = %v, error = %v", count, err)
+	}
+
+	if expired.Status != types.TransferStatusRefunded || sender.Balance != 700 {
+		t.Errorf("ExpireClaims(): transfer = %v, sender = %v", expired, sender)
+	}
+
+	err = svc.CheckLedger()
+	if err != nil {
+		t.Error(err)
+	}
+
+	report := svc.Reconcile()
+	if len(report.Discrepancies) != 0 {
+		t.Errorf("Reconcile(): got %v", report.Discrepancies)
+	}
+
+	imported := &Service{clock: func() time.Time { return now.Add(-time.Hour) }}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := imported.FindTransferByID(claimed.ID)
+	if err != nil || got.Status != types.TransferStatusPending || got.Amount != 200 {
+		t.Errorf("Import(): transfer = %v, error = %v", got, err)
+	}
+
+	if len(imported.Transfers()) != 3 || len(imported.Reconcile().Discrepancies) != 0 {
+		t.Errorf("Import(): transfers = %v, reconcile = %v", imported.Transfers(), imported.Reconcile())
+	}
+
+	account, err := imported.RegisterAccount("+992988000033")
+	if err != nil || account.Balance != 200 {
+		t.Errorf("RegisterAccount(): account = %v, error = %v", account, err)
+	}
+}
+
+func TestService_PayToPhone_expiredClaim(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	svc := &Service{clock: func() time.Time { return now }}
+	svc.SetClaimTTL(time.Hour)
+
+	sender, err := svc.RegisterAccount("+992988000011")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(sender.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := svc.PayToPhone(sender.ID, "+992988000022", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expiry runs only by ExpireClaims, registration doesn't credit expired claim
+	now = now.Add(2 * time.Hour)
+	recipient, err := svc.RegisterAccount("+992988000022")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recipient.Balance != 0 || sender.Balance != 0 || transfer.Status != types.TransferStatusPending {
+		t.Errorf("RegisterAccount(): recipient = %v, sender = %v, transfer = %v", recipient, sender, transfer)
+	}
+
+	count, err := svc.ExpireClaims()
+	if err != nil || count != 1 || sender.Balance != 100 || transfer.Status != types.TransferStatusRefunded {
+		t.Errorf("ExpireClaims(): count = %v, sender = %v, transfer = %v, error = %v", count, sender, transfer, err)
+	}
+
+	dir := t.TempDir()
+	tests := []struct {
+		line  string
+		phone types.Phone
+	}{
+		{line: "t1;1;0;992 98 800 00 33;100;PENDING;1;2\n", phone: "+992988000033"},
+		{line: "t1;1;0;+992988000033;100;LOST;1;2\n"},
+		{line: "t1;1;0;phone;100;PENDING;1;2\n"},
+	}
+	for _, test := range tests {
+		err = ioutil.WriteFile(dir+"/transfers.dump", []byte(test.line), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		imported := &Service{}
+		err = imported.actionByTransfers(dir + "/transfers.dump")
+		if test.phone != "" {
+			if err != nil || len(imported.transfers) != 1 || imported.transfers[0].Phone != test.phone {
+				t.Errorf("actionByTransfers(%q): phone must be normalized, transfers = %v, error = %v", test.line, imported.Transfers(), err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, ErrWrongFormat) {
+			t.Errorf("actionByTransfers(%q): must return ErrWrongFormat, returned = %v", test.line, err)
+		}
+	}
+}
+
+func TestService_ChangePhone(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	svc := &Service{clock: func() time.Time { return now }}
+
+	account, err := svc.RegisterAccount("+992988000011")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := svc.RegisterAccount("+992988000022")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(other.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.PayToPhone(other.ID, "+992988000033", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.ChangePhone(account.ID, "+992988000022")
+	if err != ErrPhoneRegistered {
+		t.Errorf("ChangePhone(): error = %v", err)
+	}
+
+	_, err = svc.ChangePhone(account.ID, "+99298")
+	if !errors.Is(err, ErrInvalidPhone) {
+		t.Errorf("ChangePhone(): error = %v", err)
+	}
+
+	change, err := svc.ChangePhone(account.ID, "98 800 00 33")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &types.PhoneChange{AccountID: account.ID, OldPhone: "+992988000011", NewPhone: "+992988000033", Time: now.Unix()}
+	if !reflect.DeepEqual(change, want) || account.Phone != "+992988000033" || account.Balance != 100 {
+		t.Errorf("ChangePhone(): change = %v, account = %v", change, account)
+	}
+
+	found, err := svc.FindAccountByPhone("+992988000033")
+	if err != nil || found != account {
+		t.Errorf("FindAccountByPhone(): got %v, error = %v", found, err)
+	}
+
+	_, err = svc.FindAccountByPhone("+992988000011")
+	moved := &PhoneMovedError{}
+	if !errors.Is(err, ErrAccountNotFound) || !errors.As(err, &moved) || moved.AccountID != account.ID || moved.NewPhone != account.Phone {
+		t.Errorf("FindAccountByPhone(): error = %v", err)
+	}
+
+	dir := t.TempDir()
+	err = svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := imported.PhoneHistory(account.ID)
+	if err != nil || !reflect.DeepEqual(history, []types.PhoneChange{*want}) {
+		t.Errorf("PhoneHistory(): got %v, error = %v", history, err)
+	}
+
+	_, err = imported.FindAccountByPhone("+992988000011")
+	if !errors.As(err, &moved) {
+		t.Errorf("FindAccountByPhone(): error = %v", err)
+	}
+
+	reused, err := imported.RegisterAccount("+992988000011")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = imported.FindAccountByPhone("+992988000011")
+	if err != nil || found != reused {
+		t.Errorf("FindAccountByPhone(): got %v, error = %v", found, err)
+	}
+}
+
+func TestService_MergeAccounts(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	svc := &Service{clock: func() time.Time { return now }}
+
+	keep, err := svc.RegisterAccount("+992988000011")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicate, err := svc.RegisterAccount("+992988000022")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := svc.RegisterAccount("+992988000033")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(duplicate.ID, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := svc.Pay(duplicate.ID, 200, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	favorite, err := svc.FavoritePayment(payment.ID, "car")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.MergeAccounts(keep.ID, keep.ID)
+	if err != ErrMergeToSelf {
+		t.Errorf("MergeAccounts(): error = %v", err)
+	}
+
+	merge, err := svc.MergeAccounts(keep.ID, duplicate.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &types.AccountMerge{AccountID: duplicate.ID, IntoAccountID: keep.ID, Phone: "+992988000022", Amount: 300, Payments: 1, Favorites: 1, Time: now.Unix()}
+	if !reflect.DeepEqual(merge, want) {
+		t.Errorf("MergeAccounts(): got %v, want %v", merge, want)
+	}
+
+	if keep.Balance != 300 || payment.AccountID != keep.ID || favorite.AccountID != keep.ID {
+		t.Errorf("MergeAccounts(): account = %v, payment = %v, favorite = %v", keep, payment, favorite)
+	}
+
+	_, err = svc.FindAccountByID(duplicate.ID)
+	merged := &AccountMergedError{}
+	if !errors.Is(err, ErrAccountNotFound) || !errors.As(err, &merged) || merged.IntoAccountID != keep.ID {
+		t.Errorf("FindAccountByID(): error = %v", err)
+	}
+
+	err = svc.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keep.Balance != 500 {
+		t.Errorf("Reject(): balance = %v", keep.Balance)
+	}
+
+	_, err = svc.MergeAccounts(last.ID, keep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.FindAccountByID(duplicate.ID)
+	if !errors.As(err, &merged) || merged.IntoAccountID != last.ID {
+		t.Errorf("FindAccountByID(): error = %v", err)
+	}
+
+	report := svc.Reconcile()
+	if len(report.Discrepancies) != 0 {
+		t.Errorf("Reconcile(): got %v", report.Discrepancies)
+	}
+
+	dir := t.TempDir()
+	err = svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := imported.FindAccountByID(last.ID)
+	if err != nil || found.Balance != 500 {
+		t.Errorf("FindAccountByID(): got %v, error = %v", found, err)
+	}
+
+	_, err = imported.FindAccountByID(keep.ID)
+	if !errors.As(err, &merged) || merged.IntoAccountID != last.ID {
+		t.Errorf("FindAccountByID(): error = %v", err)
+	}
+
+	registered, err := imported.RegisterAccount("+992988000022")
+	if err != nil || registered.ID != last.ID+1 {
+		t.Errorf("RegisterAccount(): got %v, error = %v", registered, err)
+	}
+}
+
+func TestService_MergeAccounts_duplicatePhones(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(dir+"/accounts.dump", []byte("1;+992988000011;300;ACTIVE\n2;992988000011;200;ACTIVE\n3;+992988000022;0;FROZEN\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &Service{}
+	err = svc.Import(dir)
+	if !errors.Is(err, ErrPhoneRegistered) {
+		t.Fatalf("Import(): must return conflict of accounts 1 and 2, error = %v", err)
+	}
+
+	merge, err := svc.MergeAccounts(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keep, err := svc.FindAccountByPhone("992988000011")
+	if err != nil || keep.ID != 1 || keep.Balance != 500 || merge.Amount != 200 || merge.Phone != "+992988000011" {
+		t.Errorf("MergeAccounts(): account = %v, merge = %v, error = %v", keep, merge, err)
+	}
+
+	if discrepancies := svc.Reconcile().Discrepancies; len(discrepancies) != 0 {
+		t.Errorf("Reconcile(): duplicate must be merged, discrepancies = %v", discrepancies)
+	}
+
+	err = svc.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = (&Service{}).Import(dir)
+	if err != nil {
+		t.Errorf("Import(): merged dumps must have no conflicts, error = %v", err)
+	}
+
+	_, err = svc.MergeAccounts(1, 3)
+	if !errors.Is(err, ErrAccountFrozen) {
+		t.Errorf("MergeAccounts(): frozen account must not be merged, error = %v", err)
+	}
+}
+
+func TestService_overflow(t *testing.T) {
+	s := &Service{}
+
+	for _, phone := range []types.Phone{"+992900000001", "+992900000002"} {
+		account, err := s.RegisterAccount(phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.DepositFrom(account.ID, math.MaxInt64, "card"+string(phone))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transactions := len(s.journal)
+	overflow := &types.OverflowError{}
+
+	err := s.Deposit(1, 1)
+	if !errors.As(err, &overflow) || !errors.Is(err, types.ErrOverflow) {
+		t.Errorf("Deposit(): error = %v", err)
+	}
+
+	_, err = s.Pay(1, math.MaxInt64, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(2, 1, "auto")
+	if !errors.As(err, &overflow) {
+		t.Errorf("Pay(): error = %v", err)
+	}
+
+	account, err := s.FindAccountByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Balance != math.MaxInt64 || len(s.journal) != transactions+1 || len(s.payments) != 1 {
+		t.Errorf("Pay(): balance = %v, transactions = %v, payments = %v", account.Balance, len(s.journal), len(s.payments))
+	}
+
+	s.payments = append(s.payments, &types.Payment{ID: "big", AccountID: 2, Amount: math.MaxInt64, Category: "auto"})
+
+	_, err = s.SumPaymentsContext(context.Background(), 2)
+	if !errors.Is(err, types.ErrOverflow) {
+		t.Errorf("SumPaymentsContext(): error = %v", err)
+	}
+
+	if s.SumPayments(2) != 0 {
+		t.Errorf("SumPayments(): got %v", s.SumPayments(2))
+	}
+
+	var last types.Progress
+	for progress := range s.SumPaymentsWithProgressContext(context.Background(), 1) {
+		last = progress
+	}
+	if !errors.Is(last.Err, types.ErrOverflow) {
+		t.Errorf("SumPaymentsWithProgressContext(): last = %v", last)
+	}
+
+	_, err = s.AggregatePayments(types.GroupByCategory, 2)
+	if !errors.Is(err, types.ErrOverflow) {
+		t.Errorf("AggregatePayments(): error = %v", err)
+	}
+
+	_, err = s.TopCategories(1, 2)
+	if !errors.Is(err, types.ErrOverflow) {
+		t.Errorf("TopCategories(): error = %v", err)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	en, err := FindLocale("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ru, err := FindLocale("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		amount   types.Money
+		currency string
+		locale   Locale
+		want     string
+	}{
+		{amount: 123450, currency: "TJS", locale: DefaultLocale, want: "1 234.50 TJS"},
+		{amount: 123450, currency: "TJS", locale: en, want: "1,234.50 TJS"},
+		{amount: -123450, currency: "TJS", locale: ru, want: "-1 234,50 TJS"},
+		{amount: 5, currency: "TJS", locale: DefaultLocale, want: "0.05 TJS"},
+		{amount: 0, currency: "TJS", locale: DefaultLocale, want: "0.00 TJS"},
+		{amount: 1234567, currency: "JPY", locale: en, want: "1,234,567 JPY"},
+		{amount: 1234567, currency: "KWD", locale: en, want: "1,234.567 KWD"},
+		{amount: math.MinInt64, currency: "TJS", locale: DefaultLocale, want: "-92 233 720 368 547 758.08 TJS"},
+	}
+
+	for _, test := range tests {
+		currency, err := FindCurrency(test.currency)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := currency.Format(test.amount, test.locale)
+		if got != test.want {
+			t.Errorf("Format(%d): got %q, want %q", test.amount, got, test.want)
+		}
+
+		if test.amount == math.MinInt64 {
+			continue
+		}
+
+		parsed, parsedCurrency, err := ParseMoney(got, test.locale)
+		if err != nil || parsed != test.amount || parsedCurrency != currency {
+			t.Errorf("ParseMoney(%q): got %d %v, error = %v", got, parsed, parsedCurrency, err)
+		}
+	}
+
+	_, err = FindLocale("fr")
+	if !errors.Is(err, ErrUnknownLocale) {
+		t.Errorf("FindLocale(): error = %v", err)
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		text   string
+		want   types.Money
+		code   string
+		reason string
+	}{
+		{text: "1 234.50 TJS", want: 123450, code: "TJS"},
+		{text: "1234.5", want: 123450, code: "TJS"},
+		{text: " +12 usd ", want: 1200, code: "USD"},
+		{text: "12.50TJS", want: 1250, code: "TJS"},
+		{text: "-0.01", want: -1, code: "TJS"},
+		{text: "12 345 678.90", want: 1234567890, code: "TJS"},
+		{text: "1 23 TJS", reason: "3 digits"},
+		{text: "1234 567", reason: "3 digits"},
+		{text: "1.234 TJS", reason: "from 1 to 2 digits"},
+		{text: "12. TJS", reason: "from 1 to 2 digits"},
+		{text: "12.5 JPY", reason: "no minor units"},
+		{text: ".50", reason: "no digits"},
+		{text: "1,5", reason: "unexpected character"},
+		{text: "12 XYZ", reason: "unknown currency"},
+		{text: "92233720368547758.08", reason: "overflow"},
+	}
+
+	for _, test := range tests {
+		got, currency, err := ParseMoney(test.text, DefaultLocale)
+		if test.reason == "" {
+			if err != nil || got != test.want || currency.Code != test.code {
+				t.Errorf("ParseMoney(%q): got %d %v, error = %v", test.text, got, currency, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("ParseMoney(%q): error = %v, want %q", test.text, err, test.reason)
+		}
+	}
+
+	_, _, err := ParseMoney("1,5", DefaultLocale)
+	amountErr := &AmountError{}
+	if !errors.As(err, &amountErr) || !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("ParseMoney(): error = %v", err)
+	}
+
+	_, _, err = ParseMoney("92233720368547758.08", DefaultLocale)
+	if !errors.Is(err, types.ErrOverflow) {
+		t.Errorf("ParseMoney(): error = %v", err)
+	}
+
+	en, err := FindLocale("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amount, _, err := ParseMoney("1,234.50", en)
+	if err != nil || amount != 123450 {
+		t.Errorf("ParseMoney(): got %v, error = %v", amount, err)
+	}
+
+	_, _, err = ParseMoney("12,34", en)
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("ParseMoney(): 12,34 isn't amount in locale en, error = %v", err)
+	}
+}
+
+func TestService_ImportHuman(t *testing.T) {
+	dir := t.TempDir()
+	dumps := map[string]string{
+		"accounts.dump":  "1;+992900000001;1 234,50 TJS;ACTIVE\n",
+		"payments.dump":  "p1;1;34,50;auto;INPROGRESS;0\n",
+		"favorites.dump": "f1;1;car;34,50 TJS;auto\n",
+	}
+	for name, data := range dumps {
+		err := ioutil.WriteFile(dir+"/"+name, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ru, err := FindLocale("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{}
+	err = s.ImportHuman(dir, ru)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := s.FindAccountByID(1)
+	if err != nil || account.Balance != 123450 {
+		t.Errorf("ImportHuman(): account = %v, error = %v", account, err)
+	}
+
+	if s.payments[0].Amount != 3450 || s.favorites[0].Amount != 3450 {
+		t.Errorf("ImportHuman(): payment = %v, favorite = %v", s.payments[0], s.favorites[0])
+	}
+
+	err = ioutil.WriteFile(dir+"/favorites.dump", []byte("f1;1;car;34,50 USD;auto\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = (&Service{}).ImportHuman(dir, ru)
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("ImportHuman(): error = %v", err)
+	}
+
+	err = (&Service{}).Import(dir)
+	if err == nil {
+		t.Error("Import(): must fail for amounts written for people")
+	}
+}
@@ -0,0 +1,181 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// Statement builds statement of account for period [from, to).
+// Opening balance is restored from current balance and entries made after from.
+func (s *Service) Statement(accountID int64, from, to time.Time) (*types.Statement, error) {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &types.Statement{
+		AccountID: account.ID,
+		Phone:     account.Phone,
+		From:      from.Unix(),
+		To:        to.Unix(),
+	}
+
+	entries := s.walletEntries(accountID)
+
+	// sum adds amount to total until the first overflow, which is returned after loops
+	var overflow error
+	sum := func(total *types.Money, amount types.Money) {
+		if overflow == nil {
+			*total, overflow = total.Add(amount)
+		}
+	}
+
+	opening := account.Balance
+	for _, entry := range entries {
+		if entry.Time >= statement.From {
+			sum(&opening, -entry.Amount)
+		}
+	}
+
+	statement.Opening = opening
+	balance := opening
+	for _, entry := range entries {
+		if entry.Time < statement.From || entry.Time >= statement.To {
+			continue
+		}
+
+		sum(&balance, entry.Amount)
+		switch entry.Type {
+		case types.EntryTypeDeposit:
+			sum(&statement.Deposits, entry.Amount)
+		case types.EntryTypePayment:
+			sum(&statement.Payments, -entry.Amount)
+		case types.EntryTypeRefund:
+			sum(&statement.Refunds, entry.Amount)
+		case types.EntryTypeTransfer:
+			sum(&statement.Transfers, entry.Amount)
+		}
+
+		statement.Lines = append(statement.Lines, types.StatementLine{
+			Entry:   entry,
+			Balance: balance,
+		})
+	}
+	statement.Closing = balance
+
+	if overflow != nil {
+		return nil, overflow
+	}
+
+	return statement, nil
+}
+
+// MonthlyStatement builds statement for calendar month in UTC.
+func (s *Service) MonthlyStatement(accountID int64, year int, month time.Month) (*types.Statement, error) {
+	from := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return s.Statement(accountID, from, from.AddDate(0, 1, 0))
+}
+
+func statementTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format("2006-01-02 15:04:05")
+}
+
+func statementMoney(amount types.Money) string {
+	return FormatMoney(amount, DefaultLocale)
+}
+
+// WriteStatementText writes statement for people, amounts are formatted like 1 234.50 TJS.
+func WriteStatementText(w io.Writer, statement *types.Statement) error {
+	_, err := fmt.Fprintf(w, "Statement for account %d (%s)\nPeriod: %s - %s\n\n",
+		statement.AccountID, statement.Phone, statementTime(statement.From), statementTime(statement.To))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%-19s  %-8s  %18s  %18s\n", "Time", "Type", "Amount", "Balance")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%-19s  %-8s  %18s  %18s\n", "", "OPENING", "", statementMoney(statement.Opening))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range statement.Lines {
+		_, err = fmt.Fprintf(w, "%-19s  %-8s  %18s  %18s\n", statementTime(line.Time), line.Type, statementMoney(line.Amount), statementMoney(line.Balance))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%-19s  %-8s  %18s  %18s\n\n", "", "CLOSING", "", statementMoney(statement.Closing))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Deposits:  %s\nPayments:  %s\nRefunds:   %s\nTransfers: %s\n",
+		statementMoney(statement.Deposits), statementMoney(statement.Payments), statementMoney(statement.Refunds), statementMoney(statement.Transfers))
+	return err
+}
+
+// WriteStatementCSV writes statement for programs, amounts are in minor units.
+func WriteStatementCSV(w io.Writer, statement *types.Statement) error {
+	writer := csv.NewWriter(w)
+
+	records := [][]string{
+		{"time", "type", "id", "payment_id", "amount", "balance"},
+		{statementTime(statement.From), "OPENING", "", "", "", strconv.FormatInt(int64(statement.Opening), 10)},
+	}
+	for _, line := range statement.Lines {
+		records = append(records, []string{
+			statementTime(line.Time),
+			string(line.Type),
+			line.ID,
+			line.PaymentID,
+			strconv.FormatInt(int64(line.Amount), 10),
+			strconv.FormatInt(int64(line.Balance), 10),
+		})
+	}
+	records = append(records, []string{statementTime(statement.To), "CLOSING", "", "", "", strconv.FormatInt(int64(statement.Closing), 10)})
+
+	err := writer.WriteAll(records)
+	if err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"time":  statementTime,
+	"money": statementMoney,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Statement {{.AccountID}}</title></head>
+<body>
+<h1>Statement for account {{.AccountID}} ({{.Phone}})</h1>
+<p>Period: {{time .From}} - {{time .To}}</p>
+<table>
+<tr><th>Time</th><th>Type</th><th>Amount</th><th>Balance</th></tr>
+<tr><td></td><td>OPENING</td><td></td><td>{{money .Opening}}</td></tr>
+{{- range .Lines}}
+<tr><td>{{time .Time}}</td><td>{{.Type}}</td><td>{{money .Amount}}</td><td>{{money .Balance}}</td></tr>
+{{- end}}
+<tr><td></td><td>CLOSING</td><td></td><td>{{money .Closing}}</td></tr>
+</table>
+<p>Deposits: {{money .Deposits}}<br>Payments: {{money .Payments}}<br>Refunds: {{money .Refunds}}<br>Transfers: {{money .Transfers}}</p>
+</body>
+</html>
+`))
+
+// WriteStatementHTML writes statement as HTML page, amounts are formatted like 1 234.50 TJS.
+func WriteStatementHTML(w io.Writer, statement *types.Statement) error {
+	return statementTemplate.Execute(w, statement)
+}
@@ -0,0 +1,110 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+var ErrAccountFrozen = errors.New("account is frozen")
+var ErrAccountClosed = errors.New("account is closed")
+var ErrAccountHasBalance = errors.New("account balance must be zero to close it")
+
+// accountStatus returns status of account, accounts without status are active.
+func accountStatus(account *types.Account) types.AccountStatus {
+	if account.Status == "" {
+		return types.AccountStatusActive
+	}
+
+	return account.Status
+}
+
+// checkActive returns error of frozen or closed account, they can't move money.
+func checkActive(account *types.Account) error {
+	switch accountStatus(account) {
+	case types.AccountStatusFrozen:
+		return ErrAccountFrozen
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	}
+
+	return nil
+}
+
+// Freeze stops deposits and payments of account until Unfreeze, freezing frozen account does nothing.
+func (s *Service) Freeze(accountID int64) (err error) {
+	defer func() {
+		s.audit("freeze", map[string]string{"account": strconv.FormatInt(accountID, 10)}, "ok", err)
+	}()
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	switch accountStatus(account) {
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	case types.AccountStatusFrozen:
+		return nil
+	}
+
+	account.Status = types.AccountStatusFrozen
+	s.publish(types.Event{Type: types.EventAccountFrozen, AccountID: account.ID})
+	return nil
+}
+
+// Unfreeze makes frozen account active, unfreezing active account does nothing.
+func (s *Service) Unfreeze(accountID int64) (err error) {
+	defer func() {
+		s.audit("unfreeze", map[string]string{"account": strconv.FormatInt(accountID, 10)}, "ok", err)
+	}()
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	switch accountStatus(account) {
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	case types.AccountStatusActive:
+		return nil
+	}
+
+	account.Status = types.AccountStatusActive
+	s.publish(types.Event{Type: types.EventAccountUnfrozen, AccountID: account.ID})
+	return nil
+}
+
+// Close closes active or frozen account with zero balance forever. Closed account
+// keeps its payments, but can't receive deposits, pay or get refunds.
+func (s *Service) Close(accountID int64) (err error) {
+	defer func() {
+		s.audit("close", map[string]string{"account": strconv.FormatInt(accountID, 10)}, "ok", err)
+	}()
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	if accountStatus(account) == types.AccountStatusClosed {
+		return ErrAccountClosed
+	}
+
+	if s.balances[WalletLedger(account.ID)] != 0 {
+		return ErrAccountHasBalance
+	}
+
+	pending := s.pendingTransfers(account.ID)
+	if pending != 0 {
+		return fmt.Errorf("%w: %d transfers wait for recipient", ErrAccountHasBalance, pending)
+	}
+
+	account.Status = types.AccountStatusClosed
+	s.publish(types.Event{Type: types.EventAccountClosed, AccountID: account.ID})
+	return nil
+}
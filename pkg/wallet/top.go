@@ -0,0 +1,211 @@
+package wallet
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+)
+
+// rankItem is candidate of top, it is ranked by amount, then by order and key.
+type rankItem struct {
+	key     string
+	order   int64
+	amount  types.Money
+	count   int
+	payment *types.Payment
+}
+
+func better(a rankItem, b rankItem) bool {
+	if a.amount != b.amount {
+		return a.amount > b.amount
+	}
+	if a.order != b.order {
+		return a.order < b.order
+	}
+	return a.key < b.key
+}
+
+// topHeap keeps at most limit best items, its root is the worst of them.
+type topHeap struct {
+	items []rankItem
+	limit int
+}
+
+func (h *topHeap) Len() int           { return len(h.items) }
+func (h *topHeap) Less(i, j int) bool { return better(h.items[j], h.items[i]) }
+func (h *topHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *topHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(rankItem))
+}
+
+func (h *topHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+func (h *topHeap) offer(item rankItem) {
+	if h.Len() < h.limit {
+		heap.Push(h, item)
+		return
+	}
+
+	if better(item, h.items[0]) {
+		h.items[0] = item
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns kept items from the best one.
+func (h *topHeap) sorted() []rankItem {
+	items := append([]rankItem{}, h.items...)
+	sort.Slice(items, func(i, j int) bool { return better(items[i], items[j]) })
+	return items
+}
+
+// MaxTopSize limits size of top, every block of scan keeps heap of this size.
+const MaxTopSize = 1000
+
+func checkTop(n int) error {
+	if n <= 0 || n > MaxTopSize {
+		return fmt.Errorf("%w: size of top must be from 1 to %d, got %d", ErrBadQuery, MaxTopSize, n)
+	}
+
+	return nil
+}
+
+// TopPayments returns n biggest payments, payments with equal amounts go in order of creation.
+func (s *Service) TopPayments(n int, goroutines int) ([]types.Payment, error) {
+	return s.TopPaymentsContext(context.Background(), n, nil, goroutines)
+}
+
+// TopPaymentsContext returns n biggest payments selected by filter, nil filter selects all payments.
+// Rejected payments are skipped like in aggregates. Every block of scan ranks its payments by own
+// heap of n items and heaps are merged by reducer, so payments aren't copied or sorted.
+func (s *Service) TopPaymentsContext(ctx context.Context, n int, filter Predicate, goroutines int) ([]types.Payment, error) {
+	err := checkTop(n)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := s.payments
+	top, err := scan(ctx, len(payments), goroutines, func(from, to int) interface{} {
+		h := &topHeap{limit: n}
+		for i := from; i < to; i++ {
+			payment := payments[i]
+			if payment.Status != types.PaymentStatusFail && (filter == nil || filter(*payment)) {
+				h.offer(rankItem{order: int64(i), amount: payment.Amount, payment: payment})
+			}
+		}
+		return h
+	}, func(accumulated interface{}, partial interface{}) interface{} {
+		h := accumulated.(*topHeap)
+		for _, item := range partial.(*topHeap).items {
+			h.offer(item)
+		}
+		return h
+	}, &topHeap{limit: n})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []types.Payment{}
+	for _, item := range top.(*topHeap).sorted() {
+		result = append(result, *item.payment)
+	}
+
+	return result, nil
+}
+
+// TopAccounts returns n accounts which spent most, accounts with equal totals go by id.
+func (s *Service) TopAccounts(n int, goroutines int) ([]types.TopEntry, error) {
+	return s.TopAccountsContext(context.Background(), n, nil, goroutines)
+}
+
+// TopAccountsContext ranks accounts by total of payments selected by filter.
+func (s *Service) TopAccountsContext(ctx context.Context, n int, filter Predicate, goroutines int) ([]types.TopEntry, error) {
+	return s.topBy(ctx, n, filter, goroutines, func(payment types.Payment) (string, int64) {
+		return strconv.FormatInt(payment.AccountID, 10), payment.AccountID
+	})
+}
+
+// TopCategories returns n categories with the biggest volume, categories with equal totals go by name.
+func (s *Service) TopCategories(n int, goroutines int) ([]types.TopEntry, error) {
+	return s.TopCategoriesContext(context.Background(), n, nil, goroutines)
+}
+
+// TopCategoriesContext ranks categories by total of payments selected by filter.
+func (s *Service) TopCategoriesContext(ctx context.Context, n int, filter Predicate, goroutines int) ([]types.TopEntry, error) {
+	return s.topBy(ctx, n, filter, goroutines, func(payment types.Payment) (string, int64) {
+		return string(payment.Category), 0
+	})
+}
+
+// topBy sums payments by key in parallel and ranks merged totals by bounded heap,
+// rejected payments are skipped like in aggregates.
+func (s *Service) topBy(ctx context.Context, n int, filter Predicate, goroutines int, key func(payment types.Payment) (string, int64)) ([]types.TopEntry, error) {
+	err := checkTop(n)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := s.payments
+	totals, err := scan(ctx, len(payments), goroutines, func(from, to int) interface{} {
+		totals := map[string]rankItem{}
+		for _, payment := range payments[from:to] {
+			if payment.Status == types.PaymentStatusFail || (filter != nil && !filter(*payment)) {
+				continue
+			}
+
+			name, order := key(*payment)
+			item := totals[name]
+			amount, err := item.amount.Add(payment.Amount)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			item.key, item.order = name, order
+			item.amount = amount
+			item.count++
+			totals[name] = item
+		}
+		return totals
+	}, func(accumulated interface{}, partial interface{}) interface{} {
+		totals := accumulated.(map[string]rankItem)
+		for name, part := range partial.(map[string]rankItem) {
+			item, ok := totals[name]
+			if !ok {
+				totals[name] = part
+				continue
+			}
+
+			amount, err := item.amount.Add(part.amount)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			item.amount = amount
+			item.count += part.count
+			totals[name] = item
+		}
+		return totals
+	}, map[string]rankItem{})
+	if err != nil {
+		return nil, err
+	}
+
+	h := &topHeap{limit: n}
+	for _, item := range totals.(map[string]rankItem) {
+		h.offer(item)
+	}
+
+	result := []types.TopEntry{}
+	for _, item := range h.sorted() {
+		result = append(result, types.TopEntry{Key: item.key, Total: item.amount, Count: item.count})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,331 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrTransferToSelf = errors.New("can't transfer money to the same account")
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// DefaultClaimTTL is how long money sent to unregistered phone waits for registration.
+const DefaultClaimTTL = 7 * 24 * time.Hour
+
+// SetClaimTTL sets how long next transfers to unregistered phones wait for registration.
+func (s *Service) SetClaimTTL(ttl time.Duration) {
+	s.claimTTL = ttl
+}
+
+// accountByPhone finds account in index of phones, index is built on first use.
+func (s *Service) accountByPhone(phone types.Phone) *types.Account {
+	if s.phones == nil {
+		s.phones = make(map[types.Phone]*types.Account)
+		for _, account := range s.accounts {
+			key := phoneKey(account.Phone)
+			if s.phones[key] == nil {
+				s.phones[key] = account
+			}
+		}
+	}
+
+	return s.phones[phoneKey(phone)]
+}
+
+// phoneKey is key of index of phones: normalized phone, or phone as is when it can't be normalized.
+// Old dumps may have the same number in different formats, the first account of them is found by phone.
+func phoneKey(phone types.Phone) types.Phone {
+	normalized, err := NormalizePhone(phone)
+	if err != nil {
+		return phone
+	}
+
+	return normalized
+}
+
+// indexPhone moves account from old phone to its current phone in index of phones.
+func (s *Service) indexPhone(account *types.Account, oldPhone types.Phone) {
+	if s.phones == nil {
+		return
+	}
+
+	if oldPhone != "" && s.phones[phoneKey(oldPhone)] == account {
+		// duplicate account of old phone must be found by it now, so index is built again
+		s.phones = nil
+		return
+	}
+
+	key := phoneKey(account.Phone)
+	if s.phones[key] == nil {
+		s.phones[key] = account
+	}
+}
+
+// PayToPhone transfers money from account to account registered for phone. If phone isn't
+// registered, money waits in claims ledger and is credited by RegisterAccount for the phone,
+// or it is returned by ExpireClaims after claim TTL.
+func (s *Service) PayToPhone(fromAccountID int64, phone types.Phone, amount types.Money) (transfer *types.Transfer, err error) {
+	defer func() {
+		result := ""
+		if transfer != nil {
+			result = transfer.ID
+		}
+		s.audit("pay-to-phone", map[string]string{
+			"account": strconv.FormatInt(fromAccountID, 10),
+			"phone":   string(phone),
+			"amount":  strconv.FormatInt(int64(amount), 10),
+		}, result, err)
+	}()
+
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	phone, err = NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.FindAccountByID(fromAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActive(account)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.balances[WalletLedger(account.ID)] < amount {
+		return nil, ErrNotEnoughBalance
+	}
+
+	transfer = &types.Transfer{
+		ID:            uuid.New().String(),
+		FromAccountID: account.ID,
+		Phone:         phone,
+		Amount:        amount,
+		Time:          s.now().Unix(),
+	}
+
+	recipient := s.accountByPhone(phone)
+	if recipient != nil {
+		if recipient.ID == account.ID {
+			return nil, ErrTransferToSelf
+		}
+
+		err = checkActive(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("recipient: %w", err)
+		}
+
+		_, err = s.post(types.EntryTypeTransfer, "", transfer.ID,
+			types.Posting{Account: WalletLedger(account.ID), Amount: -amount},
+			types.Posting{Account: WalletLedger(recipient.ID), Amount: amount},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer.ToAccountID = recipient.ID
+		transfer.Status = types.TransferStatusCompleted
+	} else {
+		_, err = s.post(types.EntryTypeTransfer, "", transfer.ID,
+			types.Posting{Account: WalletLedger(account.ID), Amount: -amount},
+			types.Posting{Account: ClaimsLedger(phone), Amount: amount},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl := s.claimTTL
+		if ttl == 0 {
+			ttl = DefaultClaimTTL
+		}
+		transfer.Status = types.TransferStatusPending
+		transfer.ExpiresAt = s.now().Add(ttl).Unix()
+	}
+
+	s.transfers = append(s.transfers, transfer)
+	s.publish(types.Event{Type: types.EventTransferSent, AccountID: account.ID, Amount: amount})
+	if recipient != nil {
+		s.publish(types.Event{Type: types.EventTransferReceived, AccountID: recipient.ID, Amount: amount})
+	}
+
+	return transfer, nil
+}
+
+// creditClaims credits pending transfers to phone of new account. Expired transfers stay
+// pending until ExpireClaims returns them to senders.
+func (s *Service) creditClaims(account *types.Account) {
+	now := s.now().Unix()
+	for _, transfer := range s.transfers {
+		if transfer.Status != types.TransferStatusPending || transfer.Phone != account.Phone || transfer.ExpiresAt <= now {
+			continue
+		}
+
+		_, err := s.post(types.EntryTypeTransfer, "", transfer.ID,
+			types.Posting{Account: ClaimsLedger(transfer.Phone), Amount: -transfer.Amount},
+			types.Posting{Account: WalletLedger(account.ID), Amount: transfer.Amount},
+		)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		transfer.ToAccountID = account.ID
+		transfer.Status = types.TransferStatusCompleted
+		s.publish(types.Event{Type: types.EventTransferReceived, AccountID: account.ID, Amount: transfer.Amount})
+	}
+}
+
+// ExpireClaims returns money of expired pending transfers to senders and returns count of them.
+func (s *Service) ExpireClaims() (count int, err error) {
+	defer func() {
+		s.audit("expire-claims", map[string]string{}, strconv.Itoa(count), err)
+	}()
+
+	return s.expireClaims(), nil
+}
+
+func (s *Service) expireClaims() int {
+	now := s.now().Unix()
+
+	count := 0
+	for _, transfer := range s.transfers {
+		if transfer.Status != types.TransferStatusPending || transfer.ExpiresAt > now {
+			continue
+		}
+
+		_, err := s.post(types.EntryTypeTransfer, "", transfer.ID,
+			types.Posting{Account: ClaimsLedger(transfer.Phone), Amount: -transfer.Amount},
+			types.Posting{Account: WalletLedger(transfer.FromAccountID), Amount: transfer.Amount},
+		)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		transfer.Status = types.TransferStatusRefunded
+		s.publish(types.Event{Type: types.EventTransferRefunded, AccountID: transfer.FromAccountID, Amount: transfer.Amount})
+		count++
+	}
+
+	return count
+}
+
+// pendingTransfers returns count of transfers of account waiting for registration of recipient.
+func (s *Service) pendingTransfers(accountID int64) int {
+	count := 0
+	for _, transfer := range s.transfers {
+		if transfer.Status == types.TransferStatusPending && transfer.FromAccountID == accountID {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (s *Service) FindTransferByID(transferID string) (*types.Transfer, error) {
+	for _, transfer := range s.transfers {
+		if transfer.ID == transferID {
+			return transfer, nil
+		}
+	}
+
+	return nil, ErrTransferNotFound
+}
+
+// Transfers returns copies of all transfers.
+func (s *Service) Transfers() []types.Transfer {
+	transfers := make([]types.Transfer, 0, len(s.transfers))
+	for _, transfer := range s.transfers {
+		transfers = append(transfers, *transfer)
+	}
+
+	return transfers
+}
+
+// exportTransfers formats transfers.dump: ID;From;To;Phone;Amount;Status;Time;ExpiresAt.
+func (s *Service) exportTransfers() string {
+	result := ""
+	for _, transfer := range s.transfers {
+		result += transfer.ID + ";"
+		result += strconv.FormatInt(transfer.FromAccountID, 10) + ";"
+		result += strconv.FormatInt(transfer.ToAccountID, 10) + ";"
+		result += string(transfer.Phone) + ";"
+		result += strconv.FormatInt(int64(transfer.Amount), 10) + ";"
+		result += string(transfer.Status) + ";"
+		result += strconv.FormatInt(transfer.Time, 10) + ";"
+		result += strconv.FormatInt(transfer.ExpiresAt, 10) + "\n"
+	}
+
+	return result
+}
+
+func (s *Service) actionByTransfers(path string) error {
+	byteData, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(ErrFileNotFound.Error())
+		return nil
+	}
+
+	for _, line := range strings.Split(string(byteData), "\n") {
+		if line == "" {
+			continue
+		}
+
+		data := strings.Split(line, ";")
+		if len(data) != 8 {
+			return fmt.Errorf("%w: transfer %q", ErrWrongFormat, line)
+		}
+
+		numbers := make([]int64, 0, 5)
+		for _, index := range []int{1, 2, 4, 6, 7} {
+			number, err := strconv.ParseInt(data[index], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: transfer %q", ErrWrongFormat, line)
+			}
+			numbers = append(numbers, number)
+		}
+
+		// claims ledger is named by normalized phone
+		phone, err := NormalizePhone(types.Phone(data[3]))
+		if err != nil {
+			return fmt.Errorf("%w: transfer %q: %v", ErrWrongFormat, line, err)
+		}
+
+		status := types.TransferStatus(data[5])
+		if status != types.TransferStatusCompleted && status != types.TransferStatusPending && status != types.TransferStatusRefunded {
+			return fmt.Errorf("%w: unknown status %q of transfer %s", ErrWrongFormat, data[5], data[0])
+		}
+
+		transfer := &types.Transfer{
+			ID:            data[0],
+			FromAccountID: numbers[0],
+			ToAccountID:   numbers[1],
+			Phone:         phone,
+			Amount:        types.Money(numbers[2]),
+			Status:        status,
+			Time:          numbers[3],
+			ExpiresAt:     numbers[4],
+		}
+
+		existing, err := s.FindTransferByID(transfer.ID)
+		if err == nil {
+			*existing = *transfer
+			continue
+		}
+
+		s.transfers = append(s.transfers, transfer)
+	}
+
+	return nil
+}
@@ -0,0 +1,435 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+	"github.com/anonimous-arn/wallet/pkg/wallet"
+	"github.com/google/uuid"
+)
+
+var ErrNotDelivered = errors.New("webhook not delivered")
+
+const (
+	SignatureHeader = "X-Wallet-Signature"
+	EventHeader     = "X-Wallet-Event"
+	DeliveryHeader  = "X-Wallet-Delivery"
+)
+
+// PaymentEvents are events which change status of payment, only they are sent to merchants.
+var PaymentEvents = []types.EventType{
+	types.EventPaymentCreated,
+	types.EventPaymentRejected,
+	types.EventPaymentRepeated,
+}
+
+type Payment struct {
+	ID        string                `json:"id"`
+	AccountID int64                 `json:"account_id"`
+	Amount    types.Money           `json:"amount"`
+	Category  types.PaymentCategory `json:"category"`
+	Status    types.PaymentStatus   `json:"status"`
+}
+
+type Payload struct {
+	ID                string          `json:"id"`
+	Event             types.EventType `json:"event"`
+	Time              int64           `json:"time"`
+	Payment           Payment         `json:"payment"`
+	OriginalPaymentID string          `json:"original_payment_id,omitempty"`
+}
+
+// Message is payload which must be delivered to URL. Undelivered messages are kept in store file.
+type Message struct {
+	URL       string          `json:"url"`
+	Event     types.EventType `json:"event"`
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+}
+
+// Dispatcher posts signed JSON payloads of payment events to registered URLs.
+// Failed deliveries are retried with exponential backoff and saved to store
+// file when all attempts fail, RetryUndelivered sends them again.
+type Dispatcher struct {
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// QueueSize is how many messages Listen keeps for delivery worker,
+	// messages which don't fit are saved to store at once.
+	QueueSize int
+
+	secret  []byte
+	store   string
+	mu      sync.Mutex
+	urls    []string
+	storeMu sync.Mutex
+}
+
+func NewDispatcher(secret string, store string) *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		QueueSize:   1000,
+		secret:      []byte(secret),
+		store:       store,
+	}
+}
+
+func (d *Dispatcher) Register(url string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, registered := range d.urls {
+		if registered == url {
+			return
+		}
+	}
+	d.urls = append(d.urls, url)
+}
+
+func (d *Dispatcher) Unregister(url string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, registered := range d.urls {
+		if registered == url {
+			d.urls = append(d.urls[:i], d.urls[i+1:]...)
+			return
+		}
+	}
+}
+
+// Listen dispatches events of subscription until it is unsubscribed and queued messages are delivered.
+// Messages are delivered and retried by worker, so Listen reads next event at once and
+// doesn't hold publisher of BackpressureBlock subscription. Messages which don't fit into queue are saved to store.
+func (d *Dispatcher) Listen(subscription *wallet.Subscription) {
+	queue := make(chan *Message, d.QueueSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// one worker keeps order of events
+		for message := range queue {
+			if d.deliver(message) {
+				continue
+			}
+
+			err := d.save([]*Message{message})
+			if err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+
+	for event := range subscription.C {
+		messages, err := d.messages(event)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		for _, message := range messages {
+			select {
+			case queue <- message:
+			default:
+				message.LastError = "queue is full"
+				err = d.save([]*Message{message})
+				if err != nil {
+					log.Print(err)
+				}
+			}
+		}
+	}
+
+	close(queue)
+	<-done
+}
+
+// Dispatch sends payment event to every registered URL and waits until it is delivered
+// or all attempts fail, Listen should be used for events of service.
+func (d *Dispatcher) Dispatch(event types.Event) error {
+	messages, err := d.messages(event)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, message := range messages {
+		if d.deliver(message) {
+			continue
+		}
+
+		failed++
+		err = d.save([]*Message{message})
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%w: %d of %d urls failed", ErrNotDelivered, failed, len(messages))
+	}
+
+	return nil
+}
+
+// messages returns message of payment event for every registered URL, other events have no messages.
+func (d *Dispatcher) messages(event types.Event) ([]*Message, error) {
+	if event.Payment == nil {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(Payload{
+		ID:    uuid.New().String(),
+		Event: event.Type,
+		Time:  event.Time,
+		Payment: Payment{
+			ID:        event.Payment.ID,
+			AccountID: event.Payment.AccountID,
+			Amount:    event.Payment.Amount,
+			Category:  event.Payment.Category,
+			Status:    event.Payment.Status,
+		},
+		OriginalPaymentID: event.OriginalPaymentID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	messages := make([]*Message, 0, len(d.urls))
+	for _, url := range d.urls {
+		messages = append(messages, &Message{URL: url, Event: event.Type, Body: body})
+	}
+
+	return messages, nil
+}
+
+// RetryUndelivered tries to deliver messages from store once more and returns how many are still undelivered.
+// Store isn't locked while messages are sent, so messages saved by Dispatch meanwhile are kept.
+func (d *Dispatcher) RetryUndelivered() (int, error) {
+	messages, err := d.Undelivered()
+	if err != nil {
+		return 0, err
+	}
+
+	retried := make(map[string]*Message, len(messages))
+	for i := range messages {
+		message := &messages[i]
+		if d.deliver(message) {
+			retried[message.key()] = nil
+		} else {
+			retried[message.key()] = message
+		}
+	}
+
+	d.storeMu.Lock()
+	defer d.storeMu.Unlock()
+
+	stored, err := d.readStore()
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := []*Message{}
+	for i := range stored {
+		message := &stored[i]
+		updated, ok := retried[message.key()]
+		switch {
+		case !ok:
+			remaining = append(remaining, message)
+		case updated != nil:
+			remaining = append(remaining, updated)
+		}
+	}
+
+	err = d.rewriteStore(remaining)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(remaining), nil
+}
+
+// key identifies message in store: payload sent to URL.
+func (m *Message) key() string {
+	var payload Payload
+	err := json.Unmarshal(m.Body, &payload)
+	if err != nil {
+		return m.URL + " " + string(m.Body)
+	}
+
+	return m.URL + " " + payload.ID
+}
+
+// Undelivered returns messages from store.
+func (d *Dispatcher) Undelivered() ([]Message, error) {
+	d.storeMu.Lock()
+	defer d.storeMu.Unlock()
+
+	return d.readStore()
+}
+
+// readStore reads messages from store, caller must hold storeMu.
+func (d *Dispatcher) readStore() ([]Message, error) {
+	messages := []Message{}
+
+	file, err := os.Open(d.store)
+	if os.IsNotExist(err) {
+		return messages, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		message := Message{}
+		err = json.Unmarshal(scanner.Bytes(), &message)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, scanner.Err()
+}
+
+// save appends messages to store.
+func (d *Dispatcher) save(messages []*Message) error {
+	d.storeMu.Lock()
+	defer d.storeMu.Unlock()
+
+	return d.writeStore(d.store, os.O_CREATE|os.O_WRONLY|os.O_APPEND, messages)
+}
+
+// rewriteStore replaces store with messages through temporary file, caller must hold storeMu.
+func (d *Dispatcher) rewriteStore(messages []*Message) error {
+	err := d.writeStore(d.store+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, messages)
+	if err != nil {
+		os.Remove(d.store + ".tmp")
+		return err
+	}
+
+	return os.Rename(d.store+".tmp", d.store)
+}
+
+func (d *Dispatcher) writeStore(path string, flags int, messages []*Message) error {
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		_, err = file.Write(append(data, '\n'))
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	return file.Close()
+}
+
+func (d *Dispatcher) deliver(message *Message) bool {
+	delay := d.BaseDelay
+	for attempt := 0; attempt < d.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+			if delay > d.MaxDelay {
+				delay = d.MaxDelay
+			}
+		}
+
+		message.Attempts++
+		err := d.post(message)
+		if err == nil {
+			return true
+		}
+
+		message.LastError = err.Error()
+	}
+
+	return false
+}
+
+func (d *Dispatcher) post(message *Message) error {
+	request, err := http.NewRequest(http.MethodPost, message.URL, bytes.NewReader(message.Body))
+	if err != nil {
+		return err
+	}
+
+	var payload Payload
+	err = json.Unmarshal(message.Body, &payload)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, string(message.Event))
+	request.Header.Set(DeliveryHeader, payload.ID)
+	request.Header.Set(SignatureHeader, Sign(d.secret, message.Body))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns value of signature header for body: hex encoded HMAC-SHA256 with secret.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks signature header of received webhook.
+func Verify(secret []byte, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -0,0 +1,279 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anonimous-arn/wallet/pkg/types"
+	"github.com/anonimous-arn/wallet/pkg/wallet"
+)
+
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	payloads []Payload
+}
+
+func newTestServer(t *testing.T, failures int) *testServer {
+	server := &testServer{failures: failures}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		if server.failures > 0 {
+			server.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !Verify([]byte("secret"), body, r.Header.Get(SignatureHeader)) {
+			t.Errorf("wrong signature = %v", r.Header.Get(SignatureHeader))
+		}
+
+		payload := Payload{}
+		err = json.Unmarshal(body, &payload)
+		if err != nil {
+			t.Error(err)
+		}
+		server.payloads = append(server.payloads, payload)
+	}))
+
+	return server
+}
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	dispatcher := NewDispatcher("secret", t.TempDir()+"/webhooks.dump")
+	dispatcher.MaxAttempts = 3
+	dispatcher.BaseDelay = time.Millisecond
+	dispatcher.MaxDelay = 2 * time.Millisecond
+	return dispatcher
+}
+
+func TestDispatcher_Listen(t *testing.T) {
+	server := newTestServer(t, 2)
+	defer server.Close()
+
+	dispatcher := newTestDispatcher(t)
+	dispatcher.Register(server.URL)
+
+	svc := &wallet.Service{}
+	subscription := svc.Subscribe(10, wallet.BackpressureBlock, PaymentEvents...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.Listen(subscription)
+	}()
+
+	account, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := svc.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscription.Unsubscribe()
+	<-done
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.payloads) != 2 {
+		t.Fatalf("Listen(): wrong payloads = %v", server.payloads)
+	}
+
+	if server.payloads[0].Event != types.EventPaymentCreated || server.payloads[1].Payment.Status != types.PaymentStatusFail {
+		t.Errorf("Listen(): wrong payloads = %v", server.payloads)
+	}
+
+	if server.payloads[1].Payment.ID != payment.ID {
+		t.Errorf("Listen(): wrong payment = %v", server.payloads[1].Payment)
+	}
+}
+
+func TestDispatcher_Listen_slowURL(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	delivered := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		mu.Lock()
+		delivered++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	dispatcher := newTestDispatcher(t)
+	dispatcher.QueueSize = 1
+	dispatcher.Register(server.URL)
+
+	svc := &wallet.Service{}
+	subscription := svc.Subscribe(0, wallet.BackpressureBlock, PaymentEvents...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.Listen(subscription)
+	}()
+
+	account, err := svc.RegisterAccount("+992900000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paid := make(chan error)
+	go func() {
+		for i := 0; i < 5; i++ {
+			_, err := svc.Pay(account.ID, 10, "auto")
+			if err != nil {
+				paid <- err
+				return
+			}
+		}
+		paid <- nil
+	}()
+
+	// URL doesn't answer, so payments must not wait for delivery
+	select {
+	case err = <-paid:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen(): publisher is blocked by delivery")
+	}
+
+	close(release)
+	subscription.Unsubscribe()
+	<-done
+
+	messages, err := dispatcher.Undelivered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if delivered+len(messages) != 5 || len(messages) == 0 || messages[0].LastError != "queue is full" {
+		t.Errorf("Listen(): delivered = %v, undelivered = %v", delivered, messages)
+	}
+}
+
+func TestDispatcher_RetryUndelivered(t *testing.T) {
+	server := newTestServer(t, 3)
+	defer server.Close()
+
+	dispatcher := newTestDispatcher(t)
+	dispatcher.Register(server.URL)
+
+	err := dispatcher.Dispatch(types.Event{
+		Type:    types.EventPaymentCreated,
+		Payment: &types.Payment{ID: "1", AccountID: 1, Amount: 100, Status: types.PaymentStatusInProgress},
+	})
+	if !errors.Is(err, ErrNotDelivered) {
+		t.Fatalf("Dispatch(): must return ErrNotDelivered, returned = %v", err)
+	}
+
+	messages, err := dispatcher.Undelivered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 1 || messages[0].Attempts != 3 || messages[0].URL != server.URL {
+		t.Fatalf("Undelivered(): wrong messages = %v", messages)
+	}
+
+	remaining, err := dispatcher.RetryUndelivered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining != 0 || len(server.payloads) != 1 || server.payloads[0].Payment.ID != "1" {
+		t.Errorf("RetryUndelivered(): remaining = %v, payloads = %v", remaining, server.payloads)
+	}
+
+	messages, err = dispatcher.Undelivered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 0 {
+		t.Errorf("Undelivered(): store must be empty, messages = %v", messages)
+	}
+}
+
+func TestDispatcher_RetryUndelivered_concurrentDispatch(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	dispatcher := newTestDispatcher(t)
+	dispatcher.Register(dead.URL)
+
+	var once sync.Once
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// message is saved by Dispatch while RetryUndelivered is sending old one
+		once.Do(func() {
+			err := dispatcher.Dispatch(types.Event{
+				Type:    types.EventPaymentCreated,
+				Payment: &types.Payment{ID: "2", AccountID: 1, Amount: 200, Status: types.PaymentStatusInProgress},
+			})
+			if !errors.Is(err, ErrNotDelivered) {
+				t.Errorf("Dispatch(): must return ErrNotDelivered, returned = %v", err)
+			}
+		})
+	}))
+	defer live.Close()
+
+	body, err := json.Marshal(Payload{ID: "delivery", Event: types.EventPaymentCreated, Payment: Payment{ID: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dispatcher.save([]*Message{{URL: live.URL, Event: types.EventPaymentCreated, Body: body, Attempts: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := dispatcher.RetryUndelivered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := dispatcher.Undelivered()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining != 1 || len(messages) != 1 || messages[0].URL != dead.URL {
+		t.Errorf("RetryUndelivered(): message saved by Dispatch must be kept, remaining = %v, messages = %v", remaining, messages)
+	}
+}